@@ -0,0 +1,244 @@
+package main
+
+import (
+	"checkerbox/internal/config"
+	"checkerbox/internal/data"
+	"checkerbox/internal/device"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// Application version - set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Paths and overrides given on command line for run command
+type runOptions struct {
+	appPath    string
+	configPath string
+	dataDir    string
+	ui         string
+	sites      int
+	// Active config file is polled and operator is offered reload when it changes
+	watch bool
+	// Config was given explicitly - with UI it is loaded on startup instead of waiting for config pick
+	loadConfig bool
+}
+
+const usage = `Usage: checkerbox <command> [flags]
+
+Commands:
+  run            run sequences with UI or headless (default command)
+  validate       validate config file
+  list-devices   list device drivers with their functions and parameters
+  reports        list stored reports or show one of them
+  version        print application version
+
+Run "checkerbox <command> -h" for flags of the command.
+`
+
+// Parses command line and runs selected subcommand - returns process exit code
+// Running without command (or with flags only) is the same as run command
+func runCommand(args []string) int {
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		return runRunCommand(args)
+	case "validate":
+		return runValidateCommand(args)
+	case "list-devices":
+		return runListDevicesCommand(args)
+	case "reports":
+		return runReportsCommand(args)
+	case "version":
+		fmt.Println("checkerbox " + version)
+		return exitPass
+	case "help":
+		fmt.Print(usage)
+		return exitPass
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", command, usage)
+		return exitConfigError
+	}
+}
+
+// Creates flag set of subcommand - parse errors are returned instead of exiting
+func newFlagSet(command, arguments string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(command, flag.ContinueOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: checkerbox %s [flags] %s\n", command, arguments)
+		flagSet.PrintDefaults()
+	}
+	return flagSet
+}
+
+// Exit code for flag parse error - help requested with -h is not an error
+func flagErrorCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitPass
+	}
+	return exitConfigError
+}
+
+func runRunCommand(args []string) int {
+	var options runOptions
+	flagSet := newFlagSet("run", "")
+	flagSet.StringVar(&options.appPath, "app", "app.yml", "application settings file")
+	flagSet.StringVar(&options.configPath, "config", filepath.Join("config", "config.yml"), "config file - its directory is listed in UI config picker")
+	flagSet.StringVar(&options.dataDir, "data-dir", ".", "directory of reports.db and log.db")
+	flagSet.StringVar(&options.ui, "ui", "", "UI engine overriding app settings ("+strings.Join(config.UiEngines, ", ")+")")
+	flagSet.IntVar(&options.sites, "sites", 0, "number of sites overriding app settings")
+	flagSet.BoolVar(&options.watch, "watch", false, "watch active config file and offer reload in UI when it changes")
+	if err := flagSet.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+	if flagSet.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Unexpected arguments: "+strings.Join(flagSet.Args(), " "))
+		return exitConfigError
+	}
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			options.loadConfig = true
+		}
+	})
+	if err := os.MkdirAll(options.dataDir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to create data directory: "+err.Error())
+		return exitConfigError
+	}
+	return runApplication(options)
+}
+
+// Validates config file given as argument and prints every problem found with its position
+// Returns exitPass if config is valid, exitConfigError otherwise
+func runValidateCommand(args []string) int {
+	flagSet := newFlagSet("validate", "<file>")
+	appPath := flagSet.String("app", "app.yml", "application settings file")
+	sites := flagSet.Int("sites", 0, "number of sites overriding app settings")
+	if err := flagSet.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		return exitConfigError
+	}
+	path := flagSet.Arg(0)
+
+	appSettings, err := config.NewAppSettings(*appPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitConfigError
+	}
+	if *sites > 0 {
+		appSettings.Sites = *sites
+	}
+	validationErrors := config.ValidateConfigFile(path, *appSettings, checkSiteSequence)
+	for _, validationError := range validationErrors {
+		fmt.Println(path + ": " + validationError.Error())
+	}
+	if len(validationErrors) > 0 {
+		return exitConfigError
+	}
+	fmt.Println(path + ": OK")
+	return exitPass
+}
+
+// Prints every registered driver with its functions and their parameters
+func runListDevicesCommand(args []string) int {
+	flagSet := newFlagSet("list-devices", "")
+	if err := flagSet.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+	printDrivers(os.Stdout)
+	return exitPass
+}
+
+func printDrivers(output io.Writer) {
+	for _, driver := range device.GetDrivers() {
+		if driver.IsBuiltin() {
+			fmt.Fprintf(output, "%s (built-in)\n", driver.Name)
+		} else {
+			fmt.Fprintf(output, "%s\n", driver.Name)
+		}
+		for _, function := range driver.Functions {
+			if function.Description != "" {
+				fmt.Fprintf(output, "  %s - %s\n", function.Name, function.Description)
+			} else {
+				fmt.Fprintf(output, "  %s\n", function.Name)
+			}
+			for _, param := range function.Params {
+				fmt.Fprintf(output, "    %s\n", param)
+			}
+		}
+	}
+}
+
+// Lists latest reports stored in reports database, or prints one report with its steps when id is given
+func runReportsCommand(args []string) int {
+	flagSet := newFlagSet("reports", "")
+	dataDir := flagSet.String("data-dir", ".", "directory of reports.db")
+	limit := flagSet.Int("limit", 20, "number of latest reports listed")
+	id := flagSet.Uint("id", 0, "id of report to show with its steps")
+	if err := flagSet.Parse(args); err != nil {
+		return flagErrorCode(err)
+	}
+
+	path := filepath.Join(*dataDir, "reports.db")
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to open reports database: "+err.Error())
+		return exitConfigError
+	}
+	reportDatabase, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to open reports database: "+err.Error())
+		return exitConfigError
+	}
+	reportDatabase.AutoMigrate(&data.Report{}, &data.StepResult{}, &data.StepMeasurement{})
+
+	if *id == 0 {
+		var reports []data.Report
+		if err := reportDatabase.Order("id desc").Limit(*limit).Find(&reports).Error; err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to read reports: "+err.Error())
+			return exitConfigError
+		}
+		fmt.Printf("%-6s %-19s %-5s %-4s %-10s %s\n", "ID", "Date", "Stage", "Site", "Result", "Source")
+		for _, report := range reports {
+			fmt.Printf("%-6v %-19s %-5v %-4v %-10s %s\n", report.ID, report.CreatedAt.Format("2006-01-02 15:04:05"), report.Stage, report.Site, report.OverallResult, report.Source)
+		}
+		return exitPass
+	}
+
+	var report data.Report
+	if err := reportDatabase.Preload("Steps.Measurements").First(&report, *id).Error; err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read report: "+err.Error())
+		return exitConfigError
+	}
+	fmt.Printf("Report %v: %s, stage %v, site %v, %s\n", report.ID, report.Source, report.Stage, report.Site, report.OverallResult)
+	for _, instrument := range strings.Split(strings.TrimSpace(report.Instruments), "\n") {
+		if instrument != "" {
+			fmt.Println("Instrument " + instrument)
+		}
+	}
+	// Reports stored before step tables were introduced have only report string
+	if len(report.Steps) == 0 {
+		fmt.Print(report.ReportString)
+		return exitPass
+	}
+	for _, step := range report.Steps {
+		fmt.Printf("%-4v %-30s %-12s %-10s %s\n", step.StepId, step.Label, step.Device, step.Result, step.Message)
+		for _, measurement := range step.Measurements {
+			fmt.Printf("     %s = %s%s %s %s\n", measurement.Name, measurement.Text, measurement.Unit, measurement.Comparison, measurement.Result)
+		}
+	}
+	return exitPass
+}
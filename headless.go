@@ -0,0 +1,89 @@
+package main
+
+import (
+	"checkerbox/internal/test"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Process exit codes returned when application runs without graphic interface
+const (
+	exitPass        = 0
+	exitFail        = 1
+	exitConfigError = 2
+	exitDeviceError = 3
+	exitAborted     = 4
+)
+
+// Function running sequence on all sites without UI - waits until every site finishes, prints per-site summary and returns process exit code
+func runHeadless(ctx *applicationContext, path string) int {
+	ctx.configSource = filepath.Base(path)
+	if err := reloadConfiguration(ctx, path); err != nil {
+		fmt.Fprintln(os.Stderr, "Configuration error: "+err.Error())
+		return exitConfigError
+	}
+	defer shutdownDevices(ctx)
+	if len(ctx.deviceErrors) > 0 {
+		for _, err := range ctx.deviceErrors {
+			fmt.Fprintln(os.Stderr, "Device initialization error: "+err.Error())
+		}
+		return exitDeviceError
+	}
+
+	prepareDevices(ctx)
+
+	// Interrupt signal aborts sequences on every site so reports are still stored
+	interruptChannel := make(chan os.Signal, 1)
+	signal.Notify(interruptChannel, os.Interrupt)
+	defer signal.Stop(interruptChannel)
+	go func() {
+		for range interruptChannel {
+			abortSequences(ctx, -1)
+		}
+	}()
+
+	// Start sequence goroutines and collect overall result of every site
+	var waitGroup sync.WaitGroup
+	var resultsMutex sync.Mutex
+	siteResults := make(map[int]test.Result)
+	for stage, stageEventLists := range ctx.sequenceEventLists {
+		for site, sequenceEventList := range stageEventLists {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				result := handleSequence(sequenceEventList, ctx, stage, site)
+				resultsMutex.Lock()
+				siteResults[site] = test.Result{Stage: stage, Site: site, Result: result}
+				resultsMutex.Unlock()
+			}()
+		}
+	}
+	waitGroup.Wait()
+
+	return printSummary(siteResults)
+}
+
+// Prints overall result of every site and resolves exit code - pass only if every site passed, aborted if any site was aborted
+func printSummary(siteResults map[int]test.Result) int {
+	sites := make([]int, 0, len(siteResults))
+	for site := range siteResults {
+		sites = append(sites, site)
+	}
+	slices.Sort(sites)
+
+	exitCode := exitPass
+	fmt.Println("Sequence summary:")
+	for _, site := range sites {
+		fmt.Printf("Stage %v Site %v: %s\n", siteResults[site].Stage, site, siteResults[site].Result)
+		if siteResults[site].Result == test.Aborted {
+			exitCode = exitAborted
+		} else if siteResults[site].Result != test.Pass && exitCode != exitAborted {
+			exitCode = exitFail
+		}
+	}
+	return exitCode
+}
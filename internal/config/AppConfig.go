@@ -1,8 +1,15 @@
 package config
 
 import (
-	"log"
+	"bytes"
+	"checkerbox/internal/test"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,36 +20,217 @@ type AppSettings struct {
 	Uiengine string `yaml:"uiengine"`
 }
 
+// Device name selects driver, name is instance name used by sequence steps to address the device
+// It allows several devices of the same type on one site - driver name is used when it is not specified
 type DeviceSettings struct {
 	Site       int            `yaml:"site"`
+	Name       string         `yaml:"name"`
 	DeviceName string         `yaml:"device_name"`
 	Settings   map[string]any `yaml:"settings"`
 }
 
+func (d DeviceSettings) GetInstanceName() string {
+	if d.Name == "" {
+		return d.DeviceName
+	}
+	return d.Name
+}
+
+// Label identifies step as a jump target and in conditions, on_fail and on_error set failure policy of the step
+// Critical step stops the run on fail or error even in noError mode
+// Capture stores data returned by device (or part matched by regex) into variables which can be used in later steps as ${name}
+// If and skip_when are conditions deciding whether step is executed. Step with goto or repeat is not sent to any device -
+// it jumps to labeled step or repeats block of steps
 type SequenceStepSettings struct {
-	StepLabel    string         `yaml:"step_label"`
-	Retry        int            `yaml:"retry"`
-	Device       string         `yaml:"device"`
-	Timeout      int            `yaml:"timeout"`
-	StepSettings map[string]any `yaml:"stepsettings"`
+	StepLabel    string                     `yaml:"step_label"`
+	Label        string                     `yaml:"label"`
+	Retry        int                        `yaml:"retry"`
+	Device       string                     `yaml:"device"`
+	Timeout      int                        `yaml:"timeout"`
+	OnFail       string                     `yaml:"on_fail"`
+	OnError      string                     `yaml:"on_error"`
+	Critical     bool                       `yaml:"critical"`
+	If           string                     `yaml:"if"`
+	SkipWhen     string                     `yaml:"skip_when"`
+	Goto         string                     `yaml:"goto"`
+	Repeat       *RepeatSettings            `yaml:"repeat"`
+	Capture      map[string]CaptureSettings `yaml:"capture"`
+	Limits       []LimitSettings            `yaml:"limits"`
+	StepSettings map[string]any             `yaml:"stepsettings"`
+}
+
+// Limits measurement returned by device is checked against - pass or fail of the step is resolved from them
+type LimitSettings struct {
+	Name       string   `yaml:"name"`
+	Comparison string   `yaml:"comparison"`
+	Low        *float64 `yaml:"low"`
+	High       *float64 `yaml:"high"`
+	Expected   string   `yaml:"expected"`
+	Unit       string   `yaml:"unit"`
+}
+
+// Converts limit settings into measurement template, checking that limits needed by comparison are set
+func (l LimitSettings) ToMeasurement() (test.Measurement, error) {
+	comparison, ok := test.ParseComparisonType(l.Comparison)
+	if !ok {
+		return test.Measurement{}, errors.New("Unknown comparison: " + l.Comparison)
+	}
+	measurement := test.Measurement{
+		Name:       l.Name,
+		Unit:       l.Unit,
+		Expected:   l.Expected,
+		Comparison: comparison,
+	}
+	if l.Expected != "" && comparison != test.EQ && comparison != test.NE {
+		return measurement, errors.New("Expected text can be compared only by EQ or NE, not " + comparison.String())
+	}
+	needsLow, needsHigh := false, false
+	switch comparison {
+	case test.EQ, test.NE:
+		needsLow = l.Expected == ""
+	case test.GT, test.GE:
+		needsLow = true
+	case test.LT, test.LE:
+		needsHigh = true
+	case test.GELE, test.GTLT, test.GELT, test.GTLE:
+		needsLow, needsHigh = true, true
+	}
+	if needsLow && l.Low == nil {
+		return measurement, errors.New("Comparison " + comparison.String() + " needs low limit")
+	}
+	if needsHigh && l.High == nil {
+		return measurement, errors.New("Comparison " + comparison.String() + " needs high limit")
+	}
+	if l.Low != nil {
+		measurement.Low = *l.Low
+	}
+	if l.High != nil {
+		measurement.High = *l.High
+	}
+	if needsLow && needsHigh && measurement.Low > measurement.High {
+		return measurement, errors.New("Low limit greater than high limit")
+	}
+	return measurement, nil
+}
+
+// Reference to sequence variable inside step settings - ${name}
+var VariableReference = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// Capture of one variable - written either as regex alone or as mapping selecting measurement by name or index
+// Regex is applied to selected measurement, or to data returned by device when no measurement is selected
+type CaptureSettings struct {
+	Measurement string `yaml:"measurement"`
+	Regex       string `yaml:"regex"`
+}
+
+func (c *CaptureSettings) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&c.Regex)
+	}
+	// Custom unmarshaller decodes node on its own, so unknown keys are checked here
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.Value != "measurement" && key.Value != "regex" {
+				return fmt.Errorf("line %v: field %s not found in capture", key.Line, key.Value)
+			}
+		}
+	}
+	type plain CaptureSettings
+	return node.Decode((*plain)(c))
+}
+
+// Block of steps repeated count times or as long as while condition holds - with both set, whichever ends loop first
+type RepeatSettings struct {
+	Count int                    `yaml:"count"`
+	While string                 `yaml:"while"`
+	Steps []SequenceStepSettings `yaml:"steps"`
+}
+
+// Failure policies - action taken when step results in fail or error
+const (
+	// Stop the sequence and go to cleanup, unless noError mode is on. Default policy
+	PolicyAbort = "abort"
+	// Carry on with the next step
+	PolicyContinue = "continue"
+	// Stop the sequence and go to cleanup, also in noError mode
+	PolicyCleanup = "cleanup"
+	// Jump to step with given label - written as goto:<label>
+	PolicyGoto = "goto"
+)
+
+// Splits failure policy into action and jump label. Empty policy resolves to abort
+func ParseFailPolicy(policy string) (string, string, error) {
+	action, label, hasLabel := strings.Cut(policy, ":")
+	switch action {
+	case "":
+		return PolicyAbort, "", nil
+	case PolicyAbort, PolicyContinue, PolicyCleanup:
+		if hasLabel {
+			return "", "", errors.New("Unexpected label in policy: " + policy)
+		}
+		return action, "", nil
+	case PolicyGoto:
+		if label == "" {
+			return "", "", errors.New("Missing label in policy: " + policy)
+		}
+		return action, label, nil
+	default:
+		return "", "", errors.New("Unknown policy: " + policy)
+	}
 }
 
+// Stage groups sites that share the same hardware setup and sequence
+type StageSettings struct {
+	Stage    int                    `yaml:"stage"`
+	Sites    []int                  `yaml:"sites"`
+	Hardware []DeviceSettings       `yaml:"hardware"`
+	Setup    []SequenceStepSettings `yaml:"setup"`
+	Sequence []SequenceStepSettings `yaml:"sequence"`
+	Cleanup  []SequenceStepSettings `yaml:"cleanup"`
+}
+
+// Setup steps run before sequence, cleanup steps run after it every time - also after failure or abort
 type Config struct {
 	Hardware []DeviceSettings       `yaml:"hardware"`
+	Setup    []SequenceStepSettings `yaml:"setup"`
 	Sequence []SequenceStepSettings `yaml:"sequence"`
+	Cleanup  []SequenceStepSettings `yaml:"cleanup"`
+	Stages   []StageSettings        `yaml:"stages"`
 }
 
-func NewAppSettings() *AppSettings {
-	appSettingsFile, err := os.ReadFile("app.yml")
+// Loads general application settings from given file - app.yml by default
+func NewAppSettings(path string) (*AppSettings, error) {
+	appSettingsFile, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, fmt.Errorf("Unable to read app settings: %w", err)
 	}
 	var appSettingsInstance AppSettings
-	err = yaml.Unmarshal(appSettingsFile, &appSettingsInstance)
-	if err != nil {
-		log.Fatal(err.Error())
+	decoder := yaml.NewDecoder(bytes.NewReader(appSettingsFile))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&appSettingsInstance); err != nil {
+		return nil, fmt.Errorf("Unable to parse app settings %s: %w", path, err)
+	}
+	if err := appSettingsInstance.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid app settings %s: %w", path, err)
+	}
+	return &appSettingsInstance, nil
+}
+
+// UI engines that can be set in app settings - none (or empty) runs application without UI
+var UiEngines = []string{"tview", "none"}
+
+// Checks that number of sites is positive, number of stages not negative and UI engine is known
+func (a AppSettings) Validate() error {
+	if a.Sites <= 0 {
+		return fmt.Errorf("sites has to be positive, got %v", a.Sites)
+	}
+	if a.Stages < 0 {
+		return fmt.Errorf("stages can't be negative, got %v", a.Stages)
+	}
+	if a.Uiengine != "" && !slices.Contains(UiEngines, a.Uiengine) {
+		return fmt.Errorf("unknown uiengine %s, available: %s", a.Uiengine, strings.Join(UiEngines, ", "))
 	}
-	return &appSettingsInstance
+	return nil
 }
 
 func NewConfig(path string) (*Config, error) {
@@ -50,14 +238,25 @@ func NewConfig(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	var unmarshalledData Config
-	err = yaml.Unmarshal(file, &unmarshalledData)
+	unmarshalledData, err := decodeConfig(file)
 	if err != nil {
 		return nil, err
 	}
 	return &unmarshalledData, nil
 }
 
+// Decodes config file rejecting keys config doesn't know, so misspelled step or device keys aren't silently ignored
+// Step and device settings are free form - they are checked against function and driver declarations instead
+func decodeConfig(file []byte) (Config, error) {
+	var unmarshalledData Config
+	decoder := yaml.NewDecoder(bytes.NewReader(file))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&unmarshalledData); err != nil && !errors.Is(err, io.EOF) {
+		return unmarshalledData, err
+	}
+	return unmarshalledData, nil
+}
+
 func (c *Config) GetSequenceConfig() []SequenceStepSettings {
 	return c.Sequence
 }
@@ -65,3 +264,60 @@ func (c *Config) GetSequenceConfig() []SequenceStepSettings {
 func (c *Config) GetHardwareConfig() []DeviceSettings {
 	return c.Hardware
 }
+
+// Returns stages declared in config. Config without stages section is treated as one stage (0) containing all sites
+// Checks that every site belongs to exactly one stage and that hardware is declared only for sites of its stage
+func (c *Config) GetStages(appSettings AppSettings) ([]StageSettings, error) {
+	if len(c.Stages) == 0 {
+		stage := StageSettings{
+			Stage:    0,
+			Hardware: c.Hardware,
+			Setup:    c.Setup,
+			Sequence: c.Sequence,
+			Cleanup:  c.Cleanup,
+		}
+		for i := range appSettings.Sites {
+			stage.Sites = append(stage.Sites, i)
+		}
+		return []StageSettings{stage}, nil
+	}
+
+	if len(c.Hardware) > 0 || len(c.Setup) > 0 || len(c.Sequence) > 0 || len(c.Cleanup) > 0 {
+		return nil, errors.New("Hardware, setup, sequence and cleanup have to be declared inside stages when stages section is used")
+	}
+	if appSettings.Stages > 0 && len(c.Stages) > appSettings.Stages {
+		return nil, fmt.Errorf("Config declares %v stages but app settings allow only %v", len(c.Stages), appSettings.Stages)
+	}
+
+	siteStages := make(map[int]int)
+	var stageIds []int
+	for _, stage := range c.Stages {
+		if slices.Contains(stageIds, stage.Stage) {
+			return nil, fmt.Errorf("Stage %v declared more than once", stage.Stage)
+		}
+		stageIds = append(stageIds, stage.Stage)
+		if len(stage.Sites) == 0 {
+			return nil, fmt.Errorf("Stage %v has no sites", stage.Stage)
+		}
+		for _, site := range stage.Sites {
+			if site < 0 || site >= appSettings.Sites {
+				return nil, fmt.Errorf("Stage %v: site %v out of range (sites: %v)", stage.Stage, site, appSettings.Sites)
+			}
+			if otherStage, ok := siteStages[site]; ok {
+				return nil, fmt.Errorf("Site %v assigned to both stage %v and stage %v", site, otherStage, stage.Stage)
+			}
+			siteStages[site] = stage.Stage
+		}
+		for _, deviceEntry := range stage.Hardware {
+			if !slices.Contains(stage.Sites, deviceEntry.Site) {
+				return nil, fmt.Errorf("Stage %v: device %s declared for site %v which doesn't belong to this stage", stage.Stage, deviceEntry.GetInstanceName(), deviceEntry.Site)
+			}
+		}
+	}
+	for i := range appSettings.Sites {
+		if _, ok := siteStages[i]; !ok {
+			return nil, fmt.Errorf("Site %v is not assigned to any stage", i)
+		}
+	}
+	return c.Stages, nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"checkerbox/internal/test"
+	"testing"
+)
+
+func TestLimitToMeasurement(t *testing.T) {
+	low, high := 1.0, 2.0
+	tests := []struct {
+		name     string
+		limit    LimitSettings
+		expected test.Measurement
+		err      string
+	}{
+		{"range", LimitSettings{Name: "v", Comparison: "gele", Low: &low, High: &high, Unit: "V"}, test.Measurement{Name: "v", Unit: "V", Low: 1, High: 2, Comparison: test.GELE}, ""},
+		{"expected text", LimitSettings{Comparison: "EQ", Expected: "OK"}, test.Measurement{Expected: "OK", Comparison: test.EQ}, ""},
+		{"log", LimitSettings{Comparison: "LOG"}, test.Measurement{Comparison: test.LOG}, ""},
+		{"unknown comparison", LimitSettings{Comparison: "ABOUT"}, test.Measurement{}, "Unknown comparison: ABOUT"},
+		{"missing low", LimitSettings{Comparison: "GE"}, test.Measurement{}, "Comparison GE needs low limit"},
+		{"missing high", LimitSettings{Comparison: "GTLT", Low: &low}, test.Measurement{}, "Comparison GTLT needs high limit"},
+		{"swapped limits", LimitSettings{Comparison: "GELE", Low: &high, High: &low}, test.Measurement{}, "Low limit greater than high limit"},
+		{"expected with GE", LimitSettings{Comparison: "GE", Low: &low, Expected: "OK"}, test.Measurement{}, "Expected text can be compared only by EQ or NE, not GE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			measurement, err := tt.limit.ToMeasurement()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Conversion failed: %v", err)
+			}
+			if measurement != tt.expected {
+				t.Errorf("Got %+v, expected %+v", measurement, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"checkerbox/internal/test"
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Condition is evaluated against results of steps executed so far, stored under step labels
+// Expression compares step result with result name and can be combined with &&, || and !, i.e:
+// "power_on == Pass && (measure != Fail || !calibrated == Done)"
+type Condition interface {
+	Evaluate(results map[string]test.ResultType) bool
+	// Labels of steps referenced in condition
+	Labels() []string
+}
+
+type orCondition struct {
+	left, right Condition
+}
+
+type andCondition struct {
+	left, right Condition
+}
+
+type notCondition struct {
+	operand Condition
+}
+
+// Comparison of step result - step that wasn't executed yet is not equal to any result
+type compareCondition struct {
+	label  string
+	equal  bool
+	result test.ResultType
+}
+
+func (c orCondition) Evaluate(results map[string]test.ResultType) bool {
+	return c.left.Evaluate(results) || c.right.Evaluate(results)
+}
+
+func (c orCondition) Labels() []string {
+	return append(c.left.Labels(), c.right.Labels()...)
+}
+
+func (c andCondition) Evaluate(results map[string]test.ResultType) bool {
+	return c.left.Evaluate(results) && c.right.Evaluate(results)
+}
+
+func (c andCondition) Labels() []string {
+	return append(c.left.Labels(), c.right.Labels()...)
+}
+
+func (c notCondition) Evaluate(results map[string]test.ResultType) bool {
+	return !c.operand.Evaluate(results)
+}
+
+func (c notCondition) Labels() []string {
+	return c.operand.Labels()
+}
+
+func (c compareCondition) Evaluate(results map[string]test.ResultType) bool {
+	result, ok := results[c.label]
+	return (ok && result == c.result) == c.equal
+}
+
+func (c compareCondition) Labels() []string {
+	return []string{c.label}
+}
+
+// Parses condition expression. Empty expression results in nil condition
+func ParseCondition(expression string) (Condition, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, nil
+	}
+	parser := conditionParser{tokens: tokenizeCondition(expression)}
+	condition, err := parser.parseOr()
+	if err != nil {
+		return nil, errors.New("Invalid condition \"" + expression + "\": " + err.Error())
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, errors.New("Invalid condition \"" + expression + "\": unexpected " + parser.tokens[parser.position])
+	}
+	return condition, nil
+}
+
+// Splits expression into operators, parentheses and words
+func tokenizeCondition(expression string) []string {
+	var tokens []string
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+		case i+1 < len(runes) && slices.Contains([]string{"&&", "||", "==", "!="}, string(runes[i:i+2])):
+			tokens = append(tokens, string(runes[i:i+2]))
+			i += 2
+		case runes[i] == '(' || runes[i] == ')' || runes[i] == '!':
+			tokens = append(tokens, string(runes[i]))
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("()!&|=", runes[i]) {
+				i++
+			}
+			if i == start {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens
+}
+
+type conditionParser struct {
+	tokens   []string
+	position int
+}
+
+func (p *conditionParser) next() string {
+	if p.position >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.position]
+	p.position++
+	return token
+}
+
+func (p *conditionParser) peek() string {
+	if p.position >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.position]
+}
+
+func (p *conditionParser) parseOr() (Condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (Condition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (Condition, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{operand}, nil
+	case "(":
+		p.next()
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return condition, nil
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *conditionParser) parseComparison() (Condition, error) {
+	label := p.next()
+	if label == "" || strings.ContainsAny(label, "()!&|=") {
+		return nil, errors.New("expected step label")
+	}
+	operator := p.next()
+	if operator != "==" && operator != "!=" {
+		return nil, errors.New("expected == or != after " + label)
+	}
+	resultName := p.next()
+	result, ok := test.ParseResultType(resultName)
+	if !ok {
+		return nil, errors.New("unknown result: " + resultName)
+	}
+	return compareCondition{
+		label:  label,
+		equal:  operator == "==",
+		result: result,
+	}, nil
+}
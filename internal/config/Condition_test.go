@@ -0,0 +1,87 @@
+package config
+
+import (
+	"checkerbox/internal/test"
+	"slices"
+	"testing"
+)
+
+func TestConditionEvaluate(t *testing.T) {
+	results := map[string]test.ResultType{
+		"power":   test.Pass,
+		"measure": test.Fail,
+		"flash":   test.Error,
+		"calib":   test.Skipped,
+		"last":    test.Skipped,
+	}
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{"power == Pass", true},
+		{"power != Pass", false},
+		{"measure == Fail", true},
+		{"flash == Error", true},
+		{"calib == Skipped", true},
+		{"last == Skipped", true},
+		{"last != Skipped", false},
+		// Step that wasn't executed isn't equal to any result
+		{"later == Pass", false},
+		{"later != Pass", true},
+		{"power == Pass && measure == Fail", true},
+		{"power == Pass && measure == Pass", false},
+		{"power == Fail || measure == Fail", true},
+		{"!power == Pass", false},
+		{"!(power == Fail || measure == Pass)", true},
+		// && binds stronger than ||
+		{"power == Pass || measure == Pass && flash == Pass", true},
+		{"(power == Pass || measure == Pass) && flash == Pass", false},
+		{"power==Pass&&!(flash!=Error)", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			condition, err := ParseCondition(tt.expression)
+			if err != nil {
+				t.Fatalf("Parsing failed: %v", err)
+			}
+			if result := condition.Evaluate(results); result != tt.expected {
+				t.Errorf("Evaluated to %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConditionLabels(t *testing.T) {
+	condition, err := ParseCondition("a == Pass && !(b != Fail || last == Done)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := condition.Labels(); !slices.Equal(labels, []string{"a", "b", "last"}) {
+		t.Errorf("Labels %v, expected [a b last]", labels)
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"a == Maybe", `Invalid condition "a == Maybe": unknown result: Maybe`},
+		{"a Pass", `Invalid condition "a Pass": expected == or != after a`},
+		{"== Pass", `Invalid condition "== Pass": expected step label`},
+		{"(a == Pass", `Invalid condition "(a == Pass": missing )`},
+		{"a == Pass b == Fail", `Invalid condition "a == Pass b == Fail": unexpected b`},
+		{"a == Pass &&", `Invalid condition "a == Pass &&": expected step label`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := ParseCondition(tt.expression)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("Expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+	if condition, err := ParseCondition("  "); condition != nil || err != nil {
+		t.Errorf("Empty condition parsed into %v, %v", condition, err)
+	}
+}
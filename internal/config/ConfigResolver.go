@@ -4,48 +4,21 @@ import (
 	"checkerbox/internal/device"
 	"checkerbox/internal/event"
 	"checkerbox/internal/userinterface"
-	"errors"
 )
 
+// Creates device declared in hardware section using driver registered under its device name
 func DeviceEntryResolver(deviceEntry DeviceSettings) (device.Device, []error) {
-	var errorTable []error
-	switch deviceEntry.DeviceName {
-	case "genericuart":
-		baudrate, ok := deviceEntry.Settings["baudrate"].(int)
-		if !ok {
-			errorTable = append(errorTable, errors.New("Unable to parse baudrate for: "+deviceEntry.DeviceName+"\nSetting default of: 115200"))
-			baudrate = 115200
-		}
-
-		address, ok := deviceEntry.Settings["address"].(string)
-		if !ok {
-			errorTable = append(errorTable, errors.New("Unable to parse address for: "+deviceEntry.DeviceName))
-			return nil, errorTable
-		}
-		genericUartDevice, err := device.NewGenericUart(deviceEntry.Site, address, baudrate)
-		if err != nil {
-			errorTable = append(errorTable, err)
-			return nil, errorTable
-		}
-		return genericUartDevice, errorTable
-	case "testdevice":
-		testDevice, err := device.NewTestDevice(deviceEntry.Site)
-		if err != nil {
-			errorTable = append(errorTable, err)
-			return nil, errorTable
-		}
-		return testDevice, errorTable
-	default:
-		errorTable = append(errorTable, errors.New("Specified device not supported: "+deviceEntry.DeviceName))
-		return nil, errorTable
-
+	initializedDevice, err := device.NewDevice(deviceEntry.DeviceName, deviceEntry.GetInstanceName(), deviceEntry.Site, deviceEntry.Settings)
+	if err != nil {
+		return nil, []error{err}
 	}
+	return initializedDevice, nil
 }
 
-func GraphicalInterfaceResolver(settingsNode AppSettings, returnChannel chan event.ControlEvent) userinterface.GraphicInterface {
+func GraphicalInterfaceResolver(settingsNode AppSettings, configDir string, returnChannel chan event.ControlEvent) userinterface.GraphicInterface {
 	switch settingsNode.Uiengine {
 	case "tview":
-		return userinterface.NewTviewInterace(settingsNode.Sites, returnChannel)
+		return userinterface.NewTviewInterace(settingsNode.Sites, configDir, returnChannel)
 	default:
 		return nil
 	}
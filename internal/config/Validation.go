@@ -0,0 +1,383 @@
+package config
+
+import (
+	"checkerbox/internal/device"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem found in config file with position of YAML node it refers to - line and column start from 1, 0 if unknown
+type ValidationError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	switch {
+	case e.Line == 0:
+		return e.Message
+	case e.Column == 0:
+		return fmt.Sprintf("line %v: %s", e.Line, e.Message)
+	default:
+		return fmt.Sprintf("line %v, column %v: %s", e.Line, e.Column, e.Message)
+	}
+}
+
+// Problem with sequence step found while site sequence is built - section key (setup, sequence or cleanup) and path
+// locate the step: index of step followed by indexes of steps nested in repeat blocks. Key is dot separated path
+// of setting inside the step the problem is in, empty for step as a whole
+type StepError struct {
+	Section string
+	Path    []int
+	Key     string
+	Message string
+}
+
+func (e StepError) Error() string {
+	return e.Message
+}
+
+// Builds sequence of one site from stage config the same way it is built when config is loaded
+// Problems with steps are returned as StepError, joined together when there are more of them
+type SequenceBuilder func(stage StageSettings, site int) error
+
+// Joins validation errors into one error, nil if there are none
+func JoinValidationErrors(validationErrors []ValidationError) error {
+	var errs []error
+	for _, validationError := range validationErrors {
+		errs = append(errs, validationError)
+	}
+	return errors.Join(errs...)
+}
+
+// Validates config file before it is loaded, without initializing any device
+func ValidateConfigFile(path string, appSettings AppSettings, build SequenceBuilder) []ValidationError {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return []ValidationError{{Message: err.Error()}}
+	}
+	return ValidateConfig(file, appSettings, build)
+}
+
+// Checks that config can be parsed, that stages are consistent with app settings, that settings of every device are
+// accepted by its driver, that every step addresses device declared on every site of its stage with function supported
+// by device driver and parameters of declared types, and that retry and timeout of every step are positive
+// Labels, conditions, jumps, repeat blocks, failure policies, captures and limits are checked by building sequence
+// of every stage with given builder - nil builder skips these checks
+func ValidateConfig(file []byte, appSettings AppSettings, build SequenceBuilder) []ValidationError {
+	var root yaml.Node
+	if err := yaml.Unmarshal(file, &root); err != nil {
+		return yamlErrors(err)
+	}
+	if len(root.Content) == 0 {
+		return []ValidationError{{Line: 1, Column: 1, Message: "Config file is empty"}}
+	}
+	document := root.Content[0]
+	// Decoded the same way config is loaded, so misspelled keys are reported
+	unmarshalledData, err := decodeConfig(file)
+	if err != nil {
+		return yamlErrors(err)
+	}
+
+	v := &validator{build: build}
+	stagesNode := mappingValue(document, "stages")
+	stages, err := unmarshalledData.GetStages(appSettings)
+	if err != nil {
+		v.add(nodeOr(stagesNode, document), "%s", err.Error())
+		return v.errors
+	}
+	for i, stage := range stages {
+		stageNode := document
+		if stagesNode != nil {
+			stageNode = sequenceItem(stagesNode, i)
+		}
+		v.validateStage(stage, stageNode)
+	}
+
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		if v.errors[i].Line != v.errors[j].Line {
+			return v.errors[i].Line < v.errors[j].Line
+		}
+		return v.errors[i].Column < v.errors[j].Column
+	})
+	return v.errors
+}
+
+type validator struct {
+	errors []ValidationError
+	build  SequenceBuilder
+	// Drivers of device instances declared on every site of currently validated stage
+	siteDevices map[int]map[string]device.Driver
+}
+
+func (v *validator) add(node *yaml.Node, format string, args ...any) {
+	validationError := ValidationError{Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		validationError.Line = node.Line
+		validationError.Column = node.Column
+	}
+	v.errors = append(v.errors, validationError)
+}
+
+func (v *validator) validateStage(stage StageSettings, stageNode *yaml.Node) {
+	v.siteDevices = make(map[int]map[string]device.Driver)
+	hardwareNode := mappingValue(stageNode, "hardware")
+	for i, deviceEntry := range stage.Hardware {
+		entryNode := nodeOr(sequenceItem(hardwareNode, i), stageNode)
+		driverNode := nodeOr(mappingValue(entryNode, "device_name"), entryNode)
+		name := deviceEntry.GetInstanceName()
+		if deviceEntry.DeviceName == "" {
+			v.add(entryNode, "Device declared on site %v has no device_name", deviceEntry.Site)
+			continue
+		}
+		driver, ok := device.GetDriver(deviceEntry.DeviceName)
+		if !ok {
+			v.add(driverNode, "Unknown device driver %s", deviceEntry.DeviceName)
+			continue
+		}
+		if driver.IsBuiltin() {
+			v.add(driverNode, "Device %s is created automatically and can't be declared in hardware section", deviceEntry.DeviceName)
+			continue
+		}
+		nameNode := nodeOr(mappingValue(entryNode, "name"), driverNode)
+		if name == device.SequenceDeviceName {
+			v.add(nameNode, "Device instance name %s is reserved", name)
+			continue
+		}
+		if _, ok := v.siteDevices[deviceEntry.Site][name]; ok {
+			v.add(nameNode, "Device instance %s declared more than once on site %v", name, deviceEntry.Site)
+			continue
+		}
+		if v.siteDevices[deviceEntry.Site] == nil {
+			v.siteDevices[deviceEntry.Site] = make(map[string]device.Driver)
+		}
+		v.siteDevices[deviceEntry.Site][name] = driver
+		v.validateDeviceSettings(deviceEntry, driver, entryNode)
+	}
+
+	sections := []struct {
+		key   string
+		steps []SequenceStepSettings
+	}{{"setup", stage.Setup}, {"sequence", stage.Sequence}, {"cleanup", stage.Cleanup}}
+	for _, section := range sections {
+		v.validateSteps(stage, section.steps, mappingValue(stageNode, section.key), stageNode)
+	}
+	v.validateSequence(stage, stageNode)
+}
+
+// Builds sequence of stage and reports every problem at position of the step (or its setting) it is in
+// Sequence is the same on every site of the stage, apart from the site itself, so it is built only once
+func (v *validator) validateSequence(stage StageSettings, stageNode *yaml.Node) {
+	if v.build == nil {
+		return
+	}
+	site := 0
+	if len(stage.Sites) > 0 {
+		site = stage.Sites[0]
+	}
+	err := v.build(stage, site)
+	if err == nil {
+		return
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, err := range errs {
+		var stepError StepError
+		if !errors.As(err, &stepError) {
+			v.add(stageNode, "%s", err.Error())
+			continue
+		}
+		node := nodeOr(mappingValue(stageNode, stepError.Section), stageNode)
+		for i, index := range stepError.Path {
+			if i > 0 {
+				node = nodeOr(mappingValue(mappingValue(node, "repeat"), "steps"), node)
+			}
+			node = nodeOr(sequenceItem(node, index), node)
+		}
+		if stepError.Key != "" {
+			for _, key := range strings.Split(stepError.Key, ".") {
+				if index, err := strconv.Atoi(key); err == nil {
+					node = nodeOr(sequenceItem(node, index), node)
+				} else {
+					node = nodeOr(mappingValue(node, key), node)
+				}
+			}
+		}
+		v.add(node, "%s", stepError.Message)
+	}
+}
+
+// Checks settings of hardware entry the same way device is created from them - every field is decoded on its own
+// so unknown and mistyped fields are reported at their position, whole settings are then checked by driver
+func (v *validator) validateDeviceSettings(deviceEntry DeviceSettings, driver device.Driver, entryNode *yaml.Node) {
+	settingsNode := nodeOr(mappingValue(entryNode, "settings"), entryNode)
+	valid := true
+	for key, value := range deviceEntry.Settings {
+		if err := driver.CheckSetting(key, value); err != nil {
+			valid = false
+			fieldNode := settingsNode
+			if keyNode := mappingKey(settingsNode, key); keyNode != nil {
+				fieldNode = keyNode
+			}
+			for _, fieldError := range yamlErrors(err) {
+				v.add(fieldNode, "Setting %s of device %s: %s", key, deviceEntry.GetInstanceName(), fieldError.Message)
+			}
+		}
+	}
+	if !valid {
+		return
+	}
+	if err := driver.CheckSettings(deviceEntry.GetInstanceName(), deviceEntry.Site, deviceEntry.Settings); err != nil {
+		v.add(settingsNode, "%s", err.Error())
+	}
+}
+
+// Checks steps sent to devices - flow control and failure policies are checked by building the sequence
+func (v *validator) validateSteps(stage StageSettings, steps []SequenceStepSettings, stepsNode, parentNode *yaml.Node) {
+	for i, step := range steps {
+		stepNode := nodeOr(sequenceItem(stepsNode, i), parentNode)
+		if step.Repeat != nil {
+			repeatNode := nodeOr(mappingValue(stepNode, "repeat"), stepNode)
+			v.validateSteps(stage, step.Repeat.Steps, mappingValue(repeatNode, "steps"), repeatNode)
+			continue
+		}
+		// Jump is not sent to any device
+		if step.Goto != "" {
+			continue
+		}
+
+		if step.Retry <= 0 {
+			v.add(nodeOr(mappingValue(stepNode, "retry"), stepNode), "Retry of step %s has to be positive", step.StepLabel)
+		}
+		if step.Timeout <= 0 {
+			v.add(nodeOr(mappingValue(stepNode, "timeout"), stepNode), "Timeout of step %s has to be positive", step.StepLabel)
+		}
+		if step.Device == "" {
+			v.add(stepNode, "Step %s has no device", step.StepLabel)
+			continue
+		}
+
+		// Device instance of the same name can use different driver on every site - function is checked against all of them
+		deviceNode := nodeOr(mappingValue(stepNode, "device"), stepNode)
+		var drivers []device.Driver
+		if step.Device == device.SequenceDeviceName {
+			driver, _ := device.GetDriver(device.SequenceDeviceName)
+			drivers = append(drivers, driver)
+		} else {
+			var missingSites []string
+			for _, site := range stage.Sites {
+				driver, ok := v.siteDevices[site][step.Device]
+				if !ok {
+					missingSites = append(missingSites, strconv.Itoa(site))
+					continue
+				}
+				if !slices.ContainsFunc(drivers, func(d device.Driver) bool { return d.Name == driver.Name }) {
+					drivers = append(drivers, driver)
+				}
+			}
+			if len(missingSites) > 0 {
+				v.add(deviceNode, "Device %s used by step %s is not declared on site %s", step.Device, step.StepLabel, strings.Join(missingSites, ", "))
+			}
+		}
+		if len(drivers) == 0 {
+			continue
+		}
+
+		settingsNode := nodeOr(mappingValue(stepNode, "stepsettings"), stepNode)
+		functionName, ok := step.StepSettings["function"].(string)
+		if !ok {
+			v.add(nodeOr(mappingValue(settingsNode, "function"), settingsNode), "Step %s has no function name", step.StepLabel)
+			continue
+		}
+		functionNode := nodeOr(mappingValue(settingsNode, "function"), settingsNode)
+		for _, driver := range drivers {
+			function, ok := driver.GetFunction(functionName)
+			if !ok {
+				v.add(functionNode, "Function %s is not supported by device %s (%s driver)", functionName, step.Device, driver.Name)
+				continue
+			}
+			_, paramErrors := function.Decode(step.StepSettings)
+			for _, paramError := range paramErrors {
+				// Value of declared parameter referencing sequence variable is known only at run time
+				declared := slices.ContainsFunc(function.Params, func(param device.Param) bool { return param.Name == paramError.Param })
+				if text, ok := step.StepSettings[paramError.Param].(string); ok && declared && VariableReference.MatchString(text) {
+					continue
+				}
+				v.add(nodeOr(mappingValue(settingsNode, paramError.Param), settingsNode), "Step %s: %s", step.StepLabel, paramError.Error())
+			}
+		}
+	}
+}
+
+// Returns value node of given key in mapping node, nil if node isn't mapping or key is not present
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Returns key node of given key in mapping node, nil if node isn't mapping or key is not present
+func mappingKey(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// Returns item of sequence node with given index, nil if node isn't sequence or index is out of range
+func sequenceItem(node *yaml.Node, index int) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+		return nil
+	}
+	return node.Content[index]
+}
+
+func nodeOr(node, fallback *yaml.Node) *yaml.Node {
+	if node == nil {
+		return fallback
+	}
+	return node
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// Converts errors returned by YAML parser into validation errors, taking line number from the message
+func yamlErrors(err error) []ValidationError {
+	messages := []string{err.Error()}
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		messages = typeError.Errors
+	}
+	var validationErrors []ValidationError
+	for _, message := range messages {
+		validationError := ValidationError{Message: message}
+		if match := yamlLine.FindStringSubmatch(message); match != nil {
+			validationError.Line, _ = strconv.Atoi(match[1])
+			validationError.Message = match[2]
+		}
+		validationErrors = append(validationErrors, validationError)
+	}
+	return validationErrors
+}
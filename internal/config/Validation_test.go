@@ -0,0 +1,39 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateVariableReferences(t *testing.T) {
+	appSettings := AppSettings{Sites: 1, Stages: 1}
+	tests := []struct {
+		name     string
+		settings string
+		errors   []string
+	}{
+		{"declared parameter", `time: "${delay}"`, nil},
+		{"declared parameter with text", `time: "1${delay}"`, nil},
+		{"unknown parameter", `tiemout: "${delay}"`, []string{"line 7, column 5: Step Wait: Missing required parameter time of function Wait", "line 8, column 14: Step Wait: Unknown parameter tiemout of function Wait"}},
+		{"invalid value without reference", `time: "soon"`, []string{"line 8, column 11: Step Wait: Parameter time has to be an int"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := `sequence:
+- step_label: Wait
+  retry: 1
+  timeout: 1000
+  device: sequence
+  stepsettings:
+    function: Wait
+    ` + tt.settings + "\n"
+			var messages []string
+			for _, validationError := range ValidateConfig([]byte(file), appSettings, nil) {
+				messages = append(messages, validationError.Error())
+			}
+			if strings.Join(messages, "\n") != strings.Join(tt.errors, "\n") {
+				t.Errorf("Validation errors:\n%s\nexpected:\n%s", strings.Join(messages, "\n"), strings.Join(tt.errors, "\n"))
+			}
+		})
+	}
+}
@@ -7,12 +7,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// Report of one site run. Steps are stored as separate rows in step results table,
+// report string keeps plain text form of the run (only form available in older reports)
 type Report struct {
 	gorm.Model
 	Source        string
+	Stage         int
 	Site          int
 	OverallResult string
 	ReportString  string
+	// Identity of instruments used by the site, one "name: identity" line per instrument
+	Instruments string
+	Steps       []StepResult
 }
 
 func NewReport() *Report {
@@ -23,6 +29,10 @@ func (r *Report) SetSource(source string) {
 	r.Source = source
 }
 
+func (r *Report) SetStage(stage int) {
+	r.Stage = stage
+}
+
 func (r *Report) SetSite(site int) {
 	r.Site = site
 }
@@ -31,7 +41,73 @@ func (r *Report) SetOverallResult(result test.ResultType) {
 	r.OverallResult = result.String()
 }
 
+// Records identity of instrument used in the run, i.e. its reply to *IDN?
+func (r *Report) AddInstrument(name, identity string) {
+	r.Instruments += name + ": " + identity + "\n"
+	r.AppendReportString("Instrument " + name + ": " + identity + " \n")
+}
+
 func (r *Report) AppendReportString(addition string) {
 	dateString := time.Now().Format("15:4:5")
 	r.ReportString += dateString + ": " + addition
 }
+
+// StepResult is one row per executed step, linked to report of the site run
+type StepResult struct {
+	gorm.Model
+	ReportID     uint
+	StepId       uint
+	Label        string
+	Device       string
+	Result       string
+	Message      string
+	Retries      int
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Measurements []StepMeasurement
+}
+
+// StepMeasurement is measurement returned by step together with limits it was checked against
+type StepMeasurement struct {
+	gorm.Model
+	StepResultID uint
+	Name         string
+	Value        float64
+	Numeric      bool
+	Text         string
+	Unit         string
+	Comparison   string
+	Low          float64
+	High         float64
+	Expected     string
+	Result       string
+}
+
+func NewStepResult(reportId uint, device string, result test.Result, startedAt, finishedAt time.Time) *StepResult {
+	stepResult := &StepResult{
+		ReportID:   reportId,
+		StepId:     result.Id,
+		Label:      result.Label,
+		Device:     device,
+		Result:     result.Result.String(),
+		Message:    result.Message,
+		Retries:    result.Retried,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}
+	for _, measurement := range result.Measurements {
+		stepResult.Measurements = append(stepResult.Measurements, StepMeasurement{
+			Name:       measurement.Name,
+			Value:      measurement.Value,
+			Numeric:    measurement.Numeric,
+			Text:       measurement.Text,
+			Unit:       measurement.Unit,
+			Comparison: measurement.Comparison.String(),
+			Low:        measurement.Low,
+			High:       measurement.High,
+			Expected:   measurement.Expected,
+			Result:     measurement.Result.String(),
+		})
+	}
+	return stepResult
+}
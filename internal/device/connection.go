@@ -0,0 +1,224 @@
+package device
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Settings of devices keeping connection open, embedded in settings of their driver - lost connection is reopened
+// starting after reconnect interval, doubling it up to max interval. Zero interval disables reconnect, times are in mS
+type ReconnectSettings struct {
+	ReconnectInterval    int `yaml:"reconnect_interval"`
+	ReconnectMaxInterval int `yaml:"reconnect_max_interval"`
+}
+
+func (s ReconnectSettings) validate(instanceName string) error {
+	if s.ReconnectInterval < 0 || s.ReconnectMaxInterval < 0 {
+		return errors.New("Reconnect interval of " + instanceName + " can't be negative")
+	}
+	return nil
+}
+
+// Keeps connection of device (serial port, socket) open - when it is lost, connection is reopened in background
+// with exponential backoff and device is notified about disconnect and reconnect
+type connection[T interface {
+	comparable
+	io.Closer
+}] struct {
+	mutex        sync.Mutex
+	current      T
+	connected    bool
+	reconnecting bool
+	// Set when connection was lost and not restored yet, reconnecting or not
+	lost   bool
+	closed bool
+	done   chan struct{}
+	// Closed when connection is closed on request while it is reconnecting
+	stopReconnect chan struct{}
+	// Name used in messages, i.e. "Port /dev/ttyUSB0"
+	name string
+	open func() (T, error)
+	// Reconnect backoff starts at min interval and doubles up to max interval - zero min interval disables reconnect
+	minInterval time.Duration
+	maxInterval time.Duration
+	notify      func(available bool, message string)
+}
+
+func newConnection[T interface {
+	comparable
+	io.Closer
+}](name string, open func() (T, error), reconnect ReconnectSettings, notify func(available bool, message string)) *connection[T] {
+	minInterval := time.Duration(reconnect.ReconnectInterval) * time.Millisecond
+	return &connection[T]{
+		name:        name,
+		open:        open,
+		minInterval: minInterval,
+		maxInterval: max(minInterval, time.Duration(reconnect.ReconnectMaxInterval)*time.Millisecond),
+		notify:      notify,
+		done:        make(chan struct{}),
+	}
+}
+
+// Opens connection unless it is already open - failure is returned to caller, no reconnect is started
+func (c *connection[T]) Open() error {
+	c.mutex.Lock()
+	if c.connected {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.mutex.Unlock()
+
+	current, err := c.open()
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	if c.closed || c.connected {
+		c.mutex.Unlock()
+		current.Close()
+		return nil
+	}
+	wasLost := c.reconnecting || c.lost
+	c.stopReconnecting()
+	c.current = current
+	c.connected = true
+	c.lost = false
+	c.mutex.Unlock()
+	// Device is notified without lock held, as after reconnect, so it can use connection right away
+	if wasLost {
+		c.notify(true, c.name+" connected")
+	}
+	return nil
+}
+
+// Checks if connection was lost and wasn't restored yet
+func (c *connection[T]) IsLost() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lost
+}
+
+// Closes connection on request and stops reconnecting - it can be opened again
+func (c *connection[T]) Disconnect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stopReconnecting()
+	if !c.connected {
+		return nil
+	}
+	var none T
+	current := c.current
+	c.current = none
+	c.connected = false
+	return current.Close()
+}
+
+// Has to be called with mutex locked
+func (c *connection[T]) stopReconnecting() {
+	if c.reconnecting {
+		close(c.stopReconnect)
+		c.reconnecting = false
+	}
+}
+
+// Returns open connection or error describing why there is none
+func (c *connection[T]) Get() (T, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.connected {
+		return c.current, nil
+	}
+	var none T
+	switch {
+	case c.closed:
+		return none, errors.New(c.name + " closed")
+	case c.reconnecting:
+		return none, errors.New(c.name + " disconnected - reconnecting")
+	default:
+		return none, errors.New(c.name + " not connected")
+	}
+}
+
+// Marks connection as lost after I/O error - closes it and starts reconnecting
+// Errors of connection that was already replaced are ignored
+func (c *connection[T]) Lost(lost T, cause error) {
+	c.mutex.Lock()
+	if !c.connected || c.current != lost || c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.current.Close()
+	var none T
+	c.current = none
+	c.connected = false
+	c.lost = true
+	c.reconnecting = c.minInterval > 0
+	stop := make(chan struct{})
+	if c.reconnecting {
+		c.stopReconnect = stop
+	}
+	reconnecting := c.reconnecting
+	c.mutex.Unlock()
+
+	c.notify(false, c.name+" disconnected: "+cause.Error())
+	if reconnecting {
+		go c.reconnect(stop)
+	}
+}
+
+func (c *connection[T]) reconnect(stop chan struct{}) {
+	interval := c.minInterval
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+		current, err := c.open()
+		if err != nil {
+			interval = min(interval*2, c.maxInterval)
+			continue
+		}
+
+		c.mutex.Lock()
+		select {
+		case <-stop:
+			c.mutex.Unlock()
+			current.Close()
+			return
+		default:
+		}
+		if c.closed {
+			c.mutex.Unlock()
+			current.Close()
+			return
+		}
+		c.current = current
+		c.connected = true
+		c.reconnecting = false
+		c.lost = false
+		c.mutex.Unlock()
+		c.notify(true, c.name+" reconnected")
+		return
+	}
+}
+
+// Closes connection and stops reconnecting - connection can't be used afterwards
+func (c *connection[T]) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	if !c.connected {
+		return nil
+	}
+	c.connected = false
+	return c.current.Close()
+}
@@ -2,12 +2,57 @@ package device
 
 import (
 	"checkerbox/internal/event"
-	"checkerbox/internal/test"
 )
 
+// Device is module with its own event loop receiving sequence events - implementations embed deviceBase
+// and register themselves in driver registry
+// Lifecycle hooks have default implementation in deviceBase - drivers override the ones they need
 type Device interface {
 	SequenceEventHandler()
-	functionResolver(event.SequenceEvent) test.Result
 	GetEventChannel() chan event.Event
+	GetName() string
+	GetSite() int
+	// Opens resources of the device (ports, connections) - called once after device is created
+	Open() error
+	// Brings device to known state - called before every run
+	Reset() error
+	// Checks that device is able to work - called after open and before every run
+	HealthCheck() error
+	// Stops event loop of the device and releases resources it holds, like open ports
+	Close() error
 	Print()
+	// Sets channel device reports its status changes to, i.e. lost and restored connection
+	SetStatusChannel(statusChannel chan<- StatusEvent)
+	setAvailability(err error)
+}
+
+// Device knowing identity of instrument it talks to, i.e. its reply to *IDN? - identity is logged when device is
+// initialized and recorded in report of every run on its site
+type Identifier interface {
+	Identity() string
+}
+
+// Status change reported by device itself - main loop shows it in UI and log
+type StatusEvent struct {
+	Site      int
+	Name      string
+	Available bool
+	Message   string
+}
+
+// Runs health check of device and marks it unavailable when it fails, or available again when it passes
+// Steps addressed to unavailable device result in error right away
+func CheckHealth(d Device) error {
+	err := d.HealthCheck()
+	d.setAvailability(err)
+	return err
+}
+
+// Resets device before run and checks its health - failed reset also makes device unavailable
+func PrepareForRun(d Device) error {
+	if err := d.Reset(); err != nil {
+		d.setAvailability(err)
+		return err
+	}
+	return CheckHealth(d)
 }
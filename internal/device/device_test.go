@@ -0,0 +1,78 @@
+package device
+
+import (
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"context"
+	"testing"
+	"time"
+)
+
+// Creates device of given driver, starts its event loop and opens it - device is closed when test ends
+func startDevice(t *testing.T, driverName string, settings map[string]any) Device {
+	t.Helper()
+	newDevice, err := NewDevice(driverName, driverName, 0, settings)
+	if err != nil {
+		t.Fatalf("Creating %s failed: %v", driverName, err)
+	}
+	go newDevice.SequenceEventHandler()
+	t.Cleanup(func() { newDevice.Close() })
+	if err := newDevice.Open(); err != nil {
+		t.Fatalf("Opening %s failed: %v", driverName, err)
+	}
+	return newDevice
+}
+
+// Sends step to event loop of device and waits for its final result, skipping progress
+func runStep(t *testing.T, testedDevice Device, stepSettings map[string]any) test.Result {
+	t.Helper()
+	stepContext, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := make(chan test.Result, 10)
+	testedDevice.GetEventChannel() <- event.Event{
+		Type:          "sequence",
+		ReturnChannel: results,
+		Data: event.SequenceEvent{
+			DeviceName:   testedDevice.GetName(),
+			Site:         testedDevice.GetSite(),
+			StepSettings: stepSettings,
+			Context:      stepContext,
+		},
+	}
+	for {
+		select {
+		case result := <-results:
+			if result.Result != test.InProgress {
+				return result
+			}
+		case <-stepContext.Done():
+			t.Fatalf("Step %v didn't finish", stepSettings)
+		}
+	}
+}
+
+// Step cancelled while waiting in device queue isn't executed by any driver
+func TestCancelledStepIsNotExecuted(t *testing.T) {
+	testedDevice := startDevice(t, "testdevice", nil)
+	stepContext, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := make(chan test.Result, 1)
+	testedDevice.GetEventChannel() <- event.Event{
+		Type:          "sequence",
+		ReturnChannel: results,
+		Data: event.SequenceEvent{
+			DeviceName:   testedDevice.GetName(),
+			Site:         testedDevice.GetSite(),
+			StepSettings: map[string]any{"function": "TestAction1"},
+			Context:      stepContext,
+		},
+	}
+	select {
+	case result := <-results:
+		if result.Result != test.Error || result.Message != "Step cancelled before execution" {
+			t.Fatalf("Expected cancelled step error, got %v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancelled step didn't finish")
+	}
+}
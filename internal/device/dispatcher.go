@@ -0,0 +1,59 @@
+package device
+
+import (
+	"checkerbox/internal/event"
+	"fmt"
+	"sync"
+)
+
+type deviceAddress struct {
+	site int
+	name string
+}
+
+// Dispatcher routes sequence events directly to event channel of device instance on given site
+// Replaces broadcasting every step to all devices through event bus
+type Dispatcher struct {
+	mutex    sync.RWMutex
+	channels map[deviceAddress]chan event.Event
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		channels: make(map[deviceAddress]chan event.Event),
+	}
+}
+
+// Registers device under its instance name and site - same name can't be registered twice on one site
+func (d *Dispatcher) Register(device Device) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	address := deviceAddress{site: device.GetSite(), name: device.GetName()}
+	if _, ok := d.channels[address]; ok {
+		return fmt.Errorf("Device %s registered twice on site %v", address.name, address.site)
+	}
+	d.channels[address] = device.GetEventChannel()
+	return nil
+}
+
+// Sends sequence event to addressed device - returns error immediately if there is no such device
+// Blocks only while channel of that device is full, until context of the step is done
+func (d *Dispatcher) Dispatch(sequenceEventWrapper event.Event) error {
+	sequenceEvent, ok := sequenceEventWrapper.Data.(event.SequenceEvent)
+	if !ok {
+		return fmt.Errorf("Dispatched event is not sequence event")
+	}
+	d.mutex.RLock()
+	channel, ok := d.channels[deviceAddress{site: sequenceEvent.Site, name: sequenceEvent.DeviceName}]
+	d.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("No such device: %s on site %v", sequenceEvent.DeviceName, sequenceEvent.Site)
+	}
+
+	select {
+	case channel <- sequenceEventWrapper:
+		return nil
+	case <-sequenceEvent.GetContext().Done():
+		return fmt.Errorf("Device %s on site %v is busy", sequenceEvent.DeviceName, sequenceEvent.Site)
+	}
+}
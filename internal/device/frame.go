@@ -0,0 +1,372 @@
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// Format of data and expected response in step settings
+var formatParam = Param{Name: "format", Type: ParamString, Default: "text", Allowed: payloadFormats, Description: "format of data and expected response"}
+
+// Parameters of functions sending data to stream - data is decoded by format and optionally wrapped in frame
+var requestParams = []Param{
+	{Name: "data", Type: ParamString, Required: true, Description: "data to send"},
+	formatParam,
+	{Name: "frame", Type: ParamString, Description: "frame template data is sent in, i.e. \"AA | {len} {data} {crc16modbus}\""},
+}
+
+// Payload formats - hex is written as byte pairs optionally separated by spaces, escaped is text with \xHH, \r, \n, \t, \0 and \\ escapes
+var payloadFormats = []string{"text", "hex", "escaped"}
+
+// Decodes data from step settings written in given format into bytes
+func decodePayload(data, format string) ([]byte, error) {
+	switch format {
+	case "text":
+		return []byte(data), nil
+	case "hex":
+		data = strings.NewReplacer("0x", "", "0X", "", " ", "", "\t", "", "\n", "", "\r", "").Replace(data)
+		decoded, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, errors.New("Invalid hex data: " + err.Error())
+		}
+		return decoded, nil
+	case "escaped":
+		return unescapePayload(data)
+	default:
+		return nil, errors.New("Unknown data format " + format + ", has to be one of: " + strings.Join(payloadFormats, ", "))
+	}
+}
+
+func unescapePayload(data string) ([]byte, error) {
+	var decoded []byte
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' {
+			decoded = append(decoded, data[i])
+			continue
+		}
+		if i+1 >= len(data) {
+			return nil, errors.New("Invalid escaped data: trailing \\")
+		}
+		i++
+		switch data[i] {
+		case 'r':
+			decoded = append(decoded, '\r')
+		case 'n':
+			decoded = append(decoded, '\n')
+		case 't':
+			decoded = append(decoded, '\t')
+		case '0':
+			decoded = append(decoded, 0)
+		case '\\':
+			decoded = append(decoded, '\\')
+		case 'x':
+			if i+2 >= len(data) {
+				return nil, errors.New("Invalid escaped data: incomplete \\x escape")
+			}
+			value, err := strconv.ParseUint(data[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, errors.New("Invalid escaped data: \\x" + data[i+1:i+3])
+			}
+			decoded = append(decoded, byte(value))
+			i += 2
+		default:
+			return nil, errors.New("Invalid escaped data: unknown escape \\" + string(data[i]))
+		}
+	}
+	return decoded, nil
+}
+
+// Returns data in readable form - binary data as hex dump, i.e. "[01 03 00 0A]"
+func formatPayload(data []byte, binary bool) string {
+	if binary {
+		return fmt.Sprintf("[% X]", data)
+	}
+	return string(data)
+}
+
+// Builds bytes sent by function from data, format and frame parameters
+func encodeRequest(params Params) ([]byte, error) {
+	payload, err := decodePayload(params.String("data"), params.String("format"))
+	if err != nil {
+		return nil, err
+	}
+	if !params.Has("frame") {
+		return payload, nil
+	}
+	frame, err := parseFrameTemplate(params.String("frame"))
+	if err != nil {
+		return nil, err
+	}
+	return frame.build(payload), nil
+}
+
+// Checks if data of request is shown as hex dump - it is when it isn't text or it is wrapped in frame
+func binaryRequest(params Params) bool {
+	return params.String("format") != "text" || params.Has("frame")
+}
+
+// Checksum algorithm that can be used in frame template
+type checksum struct {
+	size         int
+	littleEndian bool
+	compute      func(data []byte) uint64
+}
+
+// Checksums by name used in frame template - CRC-16/MODBUS is sent little endian, others big endian unless :le is given
+var checksums = map[string]checksum{
+	"sum8":        {size: 1, compute: sum8},
+	"xor8":        {size: 1, compute: xor8},
+	"crc16modbus": {size: 2, littleEndian: true, compute: crc16Modbus},
+	"crc16ccitt":  {size: 2, compute: crc16Ccitt},
+	"crc32":       {size: 4, compute: func(data []byte) uint64 { return uint64(crc32.ChecksumIEEE(data)) }},
+}
+
+func sum8(data []byte) uint64 {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return uint64(sum)
+}
+
+func xor8(data []byte) uint64 {
+	var sum byte
+	for _, b := range data {
+		sum ^= b
+	}
+	return uint64(sum)
+}
+
+// CRC-16/MODBUS - reflected polynomial 0xA001, initial value 0xFFFF
+func crc16Modbus(data []byte) uint64 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return uint64(crc)
+}
+
+// CRC-16/CCITT-FALSE - polynomial 0x1021, initial value 0xFFFF
+func crc16Ccitt(data []byte) uint64 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return uint64(crc)
+}
+
+// Field of frame template - fixed bytes, data, length of data or checksum
+type frameField struct {
+	kind         string
+	bytes        []byte
+	size         int
+	littleEndian bool
+	checksum     checksum
+}
+
+// Frame template is list of space separated fields:
+// hex bytes (i.e. "AA 55"), {data}, {len} with optional size and endianness ({len:2}, {len:2:le}) and checksums
+// ({sum8}, {xor8}, {crc16modbus}, {crc16ccitt}, {crc32}, optionally with :le or :be). Checksum covers bytes
+// before it starting from the beginning of frame, or from "|" marker when template has it
+type frameTemplate struct {
+	fields        []frameField
+	coverageStart int
+}
+
+func parseFrameTemplate(template string) (*frameTemplate, error) {
+	frame := &frameTemplate{}
+	for _, token := range strings.Fields(template) {
+		if token == "|" {
+			frame.coverageStart = len(frame.fields)
+			continue
+		}
+		if !strings.HasPrefix(token, "{") || !strings.HasSuffix(token, "}") {
+			decoded, err := hex.DecodeString(strings.TrimPrefix(token, "0x"))
+			if err != nil {
+				return nil, errors.New("Invalid frame template: " + token + " is not hex byte or field")
+			}
+			frame.fields = append(frame.fields, frameField{kind: "bytes", bytes: decoded})
+			continue
+		}
+
+		parts := strings.Split(strings.Trim(token, "{}"), ":")
+		field := frameField{kind: parts[0]}
+		options := parts[1:]
+		switch {
+		case field.kind == "data":
+		case field.kind == "len":
+			field.size = 1
+			if len(options) > 0 && options[0] != "le" && options[0] != "be" {
+				size, err := strconv.Atoi(options[0])
+				if err != nil || size < 1 || size > 4 {
+					return nil, errors.New("Invalid frame template: size of " + token + " has to be 1 to 4 bytes")
+				}
+				field.size = size
+				options = options[1:]
+			}
+		default:
+			checksum, ok := checksums[field.kind]
+			if !ok {
+				return nil, errors.New("Invalid frame template: unknown field " + token)
+			}
+			field.kind = "checksum"
+			field.checksum = checksum
+			field.size = checksum.size
+			field.littleEndian = checksum.littleEndian
+		}
+		for _, option := range options {
+			switch option {
+			case "le":
+				field.littleEndian = true
+			case "be":
+				field.littleEndian = false
+			default:
+				return nil, errors.New("Invalid frame template: unknown option " + option + " of " + token)
+			}
+		}
+		frame.fields = append(frame.fields, field)
+	}
+	if len(frame.fields) == 0 {
+		return nil, errors.New("Invalid frame template: no fields")
+	}
+	return frame, nil
+}
+
+// Builds frame around data
+func (f *frameTemplate) build(data []byte) []byte {
+	var frame []byte
+	coverageStart := 0
+	for i, field := range f.fields {
+		if i == f.coverageStart {
+			coverageStart = len(frame)
+		}
+		switch field.kind {
+		case "bytes":
+			frame = append(frame, field.bytes...)
+		case "data":
+			frame = append(frame, data...)
+		case "len":
+			frame = appendUint(frame, uint64(len(data)), field.size, field.littleEndian)
+		case "checksum":
+			frame = appendUint(frame, field.checksum.compute(frame[coverageStart:]), field.size, field.littleEndian)
+		}
+	}
+	return frame
+}
+
+// Checks if end of received frame can be found without waiting for line to become idle - data has to have length field
+func (f *frameTemplate) selfDelimited() bool {
+	for _, field := range f.fields {
+		if field.kind == "data" {
+			return false
+		}
+		if field.kind == "len" {
+			return true
+		}
+	}
+	return true
+}
+
+// Parses received frame and returns data it holds - complete is false when more bytes are needed
+// Error is returned when fixed bytes, checksum or frame length don't match template
+func (f *frameTemplate) parse(received []byte) (data []byte, complete bool, err error) {
+	position := 0
+	coverageStart := 0
+	dataLength := -1
+	for i, field := range f.fields {
+		if i == f.coverageStart {
+			coverageStart = position
+		}
+		size := field.size
+		switch field.kind {
+		case "bytes":
+			size = len(field.bytes)
+		case "data":
+			size = dataLength
+			if size < 0 {
+				size = len(received) - position - f.fixedSizeFrom(i+1)
+			}
+		}
+		if size < 0 || position+size > len(received) {
+			return nil, false, nil
+		}
+		value := received[position : position+size]
+
+		switch field.kind {
+		case "bytes":
+			if !bytes.Equal(value, field.bytes) {
+				return nil, true, fmt.Errorf("expected %s at offset %v, received %s", formatPayload(field.bytes, true), position, formatPayload(value, true))
+			}
+		case "data":
+			data = value
+		case "len":
+			dataLength = int(readUint(value, field.littleEndian))
+		case "checksum":
+			expected := field.checksum.compute(received[coverageStart:position])
+			if readUint(value, field.littleEndian) != expected {
+				calculated := appendUint(nil, expected, field.size, field.littleEndian)
+				return nil, true, errors.New("checksum " + formatPayload(value, true) + " doesn't match calculated " + formatPayload(calculated, true))
+			}
+		}
+		position += size
+	}
+	if position < len(received) {
+		return nil, true, fmt.Errorf("%v unexpected bytes after end of frame", len(received)-position)
+	}
+	return data, true, nil
+}
+
+// Number of bytes taken by fields from given index on, which all have to be of fixed size
+func (f *frameTemplate) fixedSizeFrom(index int) int {
+	size := 0
+	for _, field := range f.fields[index:] {
+		if field.kind == "bytes" {
+			size += len(field.bytes)
+		} else {
+			size += field.size
+		}
+	}
+	return size
+}
+
+func appendUint(data []byte, value uint64, size int, littleEndian bool) []byte {
+	for i := range size {
+		shift := 8 * (size - 1 - i)
+		if littleEndian {
+			shift = 8 * i
+		}
+		data = append(data, byte(value>>shift))
+	}
+	return data
+}
+
+func readUint(data []byte, littleEndian bool) uint64 {
+	var value uint64
+	for i := range data {
+		b := data[i]
+		if littleEndian {
+			b = data[len(data)-1-i]
+		}
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
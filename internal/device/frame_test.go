@@ -0,0 +1,187 @@
+package device
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	// Check values of "123456789" from CRC catalogue, sum and xor computed by hand
+	check := []byte("123456789")
+	tests := []struct {
+		name     string
+		expected uint64
+	}{
+		{"crc16modbus", 0x4B37},
+		{"crc16ccitt", 0x29B1},
+		{"crc32", 0xCBF43926},
+		{"sum8", 0xDD},
+		{"xor8", 0x31},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if value := checksums[tt.name].compute(check); value != tt.expected {
+				t.Errorf("Computed 0x%X, expected 0x%X", value, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		format   string
+		expected []byte
+		err      string
+	}{
+		{"text", "AT\r\n", "text", []byte("AT\r\n"), ""},
+		{"hex with spaces", "01 03 0a FF", "hex", []byte{0x01, 0x03, 0x0A, 0xFF}, ""},
+		{"hex with prefixes", "0x01 0X02\n03", "hex", []byte{0x01, 0x02, 0x03}, ""},
+		{"odd hex", "01 0", "hex", nil, "Invalid hex data: encoding/hex: odd length hex string"},
+		{"invalid hex", "01 GG", "hex", nil, "Invalid hex data: encoding/hex: invalid byte: U+0047 'G'"},
+		{"escaped", `A\x01\r\n\t\0\\`, "escaped", []byte{'A', 0x01, '\r', '\n', '\t', 0, '\\'}, ""},
+		{"incomplete escape", `A\x1`, "escaped", nil, `Invalid escaped data: incomplete \x escape`},
+		{"invalid escape", `\xZZ`, "escaped", nil, `Invalid escaped data: \xZZ`},
+		{"unknown escape", `\q`, "escaped", nil, `Invalid escaped data: unknown escape \q`},
+		{"trailing backslash", `A\`, "escaped", nil, `Invalid escaped data: trailing \`},
+		{"unknown format", "A", "base64", nil, "Unknown data format base64, has to be one of: text, hex, escaped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := decodePayload(tt.data, tt.format)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(decoded, tt.expected) {
+				t.Errorf("Decoded % X, %v, expected % X", decoded, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFrameBuild(t *testing.T) {
+	tests := []struct {
+		template string
+		data     []byte
+		expected []byte
+	}{
+		// Modbus RTU read holding registers request
+		{"{data} {crc16modbus}", []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}, []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A, 0xC5, 0xCD}},
+		{"{data} {crc16ccitt}", []byte("123456789"), append([]byte("123456789"), 0x29, 0xB1)},
+		{"{data} {crc16ccitt:le}", []byte("123456789"), append([]byte("123456789"), 0xB1, 0x29)},
+		{"{data} {crc32}", []byte("123456789"), append([]byte("123456789"), 0xCB, 0xF4, 0x39, 0x26)},
+		{"{len} {data}", []byte{0xAA, 0xBB}, []byte{0x02, 0xAA, 0xBB}},
+		{"{len:2:le} {data}", []byte{0xAA}, []byte{0x01, 0x00, 0xAA}},
+		// Checksums cover bytes from | marker
+		{"AA 55 | {len} {data} {sum8}", []byte{0x10, 0x20}, []byte{0xAA, 0x55, 0x02, 0x10, 0x20, 0x32}},
+		{"02 | {data} {xor8} 03", []byte{0x0F, 0xF0}, []byte{0x02, 0x0F, 0xF0, 0xFF, 0x03}},
+		{"{data}", []byte{0x01}, []byte{0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			frame, err := parseFrameTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("Parsing template failed: %v", err)
+			}
+			built := frame.build(tt.data)
+			if !bytes.Equal(built, tt.expected) {
+				t.Fatalf("Built % X, expected % X", built, tt.expected)
+			}
+			// Frame parses back into the same data
+			data, complete, err := frame.parse(built)
+			if err != nil || !complete || !bytes.Equal(data, tt.data) {
+				t.Errorf("Parsed % X, complete %v, %v", data, complete, err)
+			}
+		})
+	}
+}
+
+func TestFrameParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		received []byte
+		data     []byte
+		complete bool
+		err      string
+	}{
+		{"data at start", "{data} {crc16modbus}", []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A, 0xC5, 0xCD}, []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}, true, ""},
+		{"data at end", "AA {len} {data}", []byte{0xAA, 0x02, 0x01, 0x02}, []byte{0x01, 0x02}, true, ""},
+		{"data at end incomplete", "AA {len} {data}", []byte{0xAA, 0x02, 0x01}, nil, false, ""},
+		{"length field incomplete", "AA {len:2} {data}", []byte{0xAA, 0x00}, nil, false, ""},
+		{"empty data", "{len} {data} {sum8}", []byte{0x00, 0x00}, []byte{}, true, ""},
+		{"wrong start byte", "AA {len} {data}", []byte{0xBB, 0x01, 0x01}, nil, true, "expected [AA] at offset 0, received [BB]"},
+		{"wrong checksum", "{data} {crc16modbus}", []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A, 0xCD, 0xC5}, nil, true, "checksum [CD C5] doesn't match calculated [C5 CD]"},
+		{"bytes after frame", "AA {len} {data}", []byte{0xAA, 0x01, 0x01, 0x02}, nil, true, "1 unexpected bytes after end of frame"},
+		{"data only", "{data}", []byte{0x01, 0x02}, []byte{0x01, 0x02}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := parseFrameTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("Parsing template failed: %v", err)
+			}
+			data, complete, err := frame.parse(tt.received)
+			if complete != tt.complete {
+				t.Errorf("Complete %v, expected %v", complete, tt.complete)
+			}
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(data, tt.data) {
+				t.Errorf("Parsed % X, %v, expected % X", data, err, tt.data)
+			}
+		})
+	}
+}
+
+func TestFrameTemplateErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{"", "Invalid frame template: no fields"},
+		{"| ", "Invalid frame template: no fields"},
+		{"ABC {data}", "Invalid frame template: ABC is not hex byte or field"},
+		{"0xZZ {data}", "Invalid frame template: 0xZZ is not hex byte or field"},
+		{"{data} {crc8}", "Invalid frame template: unknown field {crc8}"},
+		{"{len:5} {data}", "Invalid frame template: size of {len:5} has to be 1 to 4 bytes"},
+		{"{data} {sum8:me}", "Invalid frame template: unknown option me of {sum8:me}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, err := parseFrameTemplate(tt.template)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("Expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestFrameSelfDelimited(t *testing.T) {
+	tests := []struct {
+		template string
+		expected bool
+	}{
+		{"AA {len} {data} {crc16modbus}", true},
+		{"AA 55 {sum8}", true},
+		{"{data} {crc16modbus}", false},
+		{"AA {data} {len}", false},
+	}
+	for _, tt := range tests {
+		frame, err := parseFrameTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("Parsing template %s failed: %v", tt.template, err)
+		}
+		if frame.selfDelimited() != tt.expected {
+			t.Errorf("Template %s self delimited %v, expected %v", tt.template, !tt.expected, tt.expected)
+		}
+	}
+}
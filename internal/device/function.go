@@ -0,0 +1,265 @@
+package device
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Type of value expected in step settings parameter
+type ParamType string
+
+const (
+	ParamString  ParamType = "string"
+	ParamInt     ParamType = "int"
+	ParamNumber  ParamType = "number"
+	ParamBool    ParamType = "bool"
+	ParamIntList ParamType = "int list"
+	ParamAny     ParamType = "any"
+)
+
+// Parameter of device function passed in stepsettings section of sequence step
+// Optional parameter missing in step settings takes default value, if there is one. Min and Max limit numeric parameters,
+// Allowed lists values string parameter can take - any value is accepted when it is empty
+type Param struct {
+	Name        string
+	Type        ParamType
+	Required    bool
+	Default     any
+	Min         *float64
+	Max         *float64
+	Allowed     []string
+	Description string
+}
+
+// Function supported by driver together with parameters it accepts
+type Function struct {
+	Name        string
+	Params      []Param
+	Description string
+}
+
+// Function call decoded by shared event loop before it reaches function resolver of device
+type Call struct {
+	Function string
+	Params   Params
+}
+
+// Parameters decoded according to function declaration - values have types declared by parameters
+// (string, int, float64, bool or []int), defaults are already applied
+type Params map[string]any
+
+func (p Params) Has(name string) bool {
+	_, ok := p[name]
+	return ok
+}
+
+func (p Params) String(name string) string {
+	value, _ := p[name].(string)
+	return value
+}
+
+func (p Params) Int(name string) int {
+	value, _ := p[name].(int)
+	return value
+}
+
+func (p Params) Float(name string) float64 {
+	value, _ := p[name].(float64)
+	return value
+}
+
+func (p Params) Bool(name string) bool {
+	value, _ := p[name].(bool)
+	return value
+}
+
+func (p Params) IntList(name string) []int {
+	value, _ := p[name].([]int)
+	return value
+}
+
+func (p Params) Value(name string) any {
+	return p[name]
+}
+
+// Problem with one parameter of function call
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e ParamError) Error() string {
+	return e.Message
+}
+
+// Returns pointer to limit value for Min and Max of parameter declaration
+func limit(value float64) *float64 {
+	return &value
+}
+
+// Decodes step settings into parameters of function - checks required parameters, types and ranges,
+// applies defaults and rejects parameters function doesn't declare. Function name itself is not a parameter
+func (f Function) Decode(settings map[string]any) (Params, []ParamError) {
+	params := make(Params)
+	var errs []ParamError
+	for _, param := range f.Params {
+		value, ok := settings[param.Name]
+		if !ok {
+			if param.Required {
+				errs = append(errs, ParamError{Param: param.Name, Message: "Missing required parameter " + param.Name + " of function " + f.Name})
+			} else if param.Default != nil {
+				params[param.Name] = param.Default
+			}
+			continue
+		}
+		decoded, err := param.Decode(value)
+		if err != nil {
+			errs = append(errs, ParamError{Param: param.Name, Message: err.Error()})
+			continue
+		}
+		params[param.Name] = decoded
+	}
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		if name != "function" && !f.hasParam(name) {
+			errs = append(errs, ParamError{Param: name, Message: "Unknown parameter " + name + " of function " + f.Name})
+		}
+	}
+	return params, errs
+}
+
+func (f Function) hasParam(name string) bool {
+	for _, param := range f.Params {
+		if param.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Converts value decoded from YAML into type declared by parameter and checks its range
+// Numeric parameters also accept strings holding number, so they can be set from sequence variables
+func (p Param) Decode(value any) (any, error) {
+	typeError := fmt.Errorf("Parameter %s has to be %s", p.Name, withArticle(string(p.Type)))
+	switch p.Type {
+	case ParamString:
+		decoded, ok := value.(string)
+		if !ok {
+			return nil, typeError
+		}
+		if len(p.Allowed) > 0 && !slices.Contains(p.Allowed, decoded) {
+			return nil, fmt.Errorf("Parameter %s has to be one of: %s", p.Name, strings.Join(p.Allowed, ", "))
+		}
+		return decoded, nil
+	case ParamInt:
+		var decoded int
+		switch typed := value.(type) {
+		case int:
+			decoded = typed
+		case string:
+			parsed, err := strconv.Atoi(strings.TrimSpace(typed))
+			if err != nil {
+				return nil, typeError
+			}
+			decoded = parsed
+		default:
+			return nil, typeError
+		}
+		return decoded, p.checkRange(float64(decoded))
+	case ParamNumber:
+		var decoded float64
+		switch typed := value.(type) {
+		case int:
+			decoded = float64(typed)
+		case float64:
+			decoded = typed
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+			if err != nil {
+				return nil, typeError
+			}
+			decoded = parsed
+		default:
+			return nil, typeError
+		}
+		return decoded, p.checkRange(decoded)
+	case ParamBool:
+		decoded, ok := value.(bool)
+		if !ok {
+			return nil, typeError
+		}
+		return decoded, nil
+	case ParamIntList:
+		list, ok := value.([]any)
+		if !ok {
+			return nil, typeError
+		}
+		var decoded []int
+		for _, entry := range list {
+			entryValue, ok := entry.(int)
+			if !ok {
+				return nil, typeError
+			}
+			if err := p.checkRange(float64(entryValue)); err != nil {
+				return nil, err
+			}
+			decoded = append(decoded, entryValue)
+		}
+		return decoded, nil
+	default:
+		return value, nil
+	}
+}
+
+func (p Param) checkRange(value float64) error {
+	if (p.Min != nil && value < *p.Min) || (p.Max != nil && value > *p.Max) {
+		return fmt.Errorf("Parameter %s out of range %s", p.Name, p.rangeString())
+	}
+	return nil
+}
+
+func (p Param) rangeString() string {
+	low, high := math.Inf(-1), math.Inf(1)
+	if p.Min != nil {
+		low = *p.Min
+	}
+	if p.Max != nil {
+		high = *p.Max
+	}
+	return fmt.Sprintf("[%v, %v]", low, high)
+}
+
+// Describes parameter for help and documentation - i.e. "count (int, optional, default 1, range [1, +Inf])"
+// or "format (string, optional, default text, one of text|hex|escaped)"
+func (p Param) String() string {
+	details := []string{string(p.Type)}
+	if p.Required {
+		details = append(details, "required")
+	} else {
+		details = append(details, "optional")
+	}
+	if p.Default != nil {
+		details = append(details, fmt.Sprintf("default %v", p.Default))
+	}
+	if p.Min != nil || p.Max != nil {
+		details = append(details, "range "+p.rangeString())
+	}
+	if len(p.Allowed) > 0 {
+		details = append(details, "one of "+strings.Join(p.Allowed, "|"))
+	}
+	description := p.Name + " (" + strings.Join(details, ", ") + ")"
+	if p.Description != "" {
+		description += " - " + p.Description
+	}
+	return description
+}
+
+func withArticle(word string) string {
+	if strings.ContainsAny(word[:1], "aeiou") {
+		return "an " + word
+	}
+	return "a " + word
+}
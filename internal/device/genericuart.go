@@ -3,114 +3,478 @@ package device
 import (
 	"checkerbox/internal/event"
 	"checkerbox/internal/test"
+	"checkerbox/internal/xmodem"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"go.bug.st/serial"
 )
 
 type GenericUart struct {
-	eventChannel chan event.Event
-	site         int
-	port         serial.Port
+	deviceBase
+	address     string
+	mode        serial.Mode
+	readTimeout time.Duration
+	waitForCTS  bool
+	connection  *connection[serial.Port]
 }
 
-func NewGenericUart(site int, address string, baudrate int) (*GenericUart, error) {
-	port, portError := initPort(address, baudrate)
-	if portError != nil {
-		return nil, portError
+// Settings from hardware section of config - defaults to 115200 8N1 and 1S read timeout. Times are in mS
+// With wait for CTS every write starts only once peer asserts CTS - it isn't hardware flow control, CTS isn't
+// watched while data is being written
+type GenericUartSettings struct {
+	Address            string `yaml:"address"`
+	SerialLineSettings `yaml:",inline"`
+	WaitForCTS         bool `yaml:"wait_for_cts"`
+	ReadTimeout        int  `yaml:"read_timeout"`
+	ReconnectSettings  `yaml:",inline"`
+}
+
+// Line settings of serial port, embedded in settings of drivers talking over it
+type SerialLineSettings struct {
+	Baudrate int     `yaml:"baudrate"`
+	DataBits int     `yaml:"data_bits"`
+	Parity   string  `yaml:"parity"`
+	StopBits float64 `yaml:"stop_bits"`
+}
+
+// Checks line settings and converts them into mode port is opened with
+func (s SerialLineSettings) mode(instanceName string) (serial.Mode, error) {
+	if s.Baudrate <= 0 {
+		return serial.Mode{}, errors.New("Baudrate of " + instanceName + " has to be positive")
 	}
+	if s.DataBits < 5 || s.DataBits > 8 {
+		return serial.Mode{}, errors.New("Data bits of " + instanceName + " have to be 5, 6, 7 or 8")
+	}
+	parity, ok := uartParities[strings.ToLower(s.Parity)]
+	if !ok {
+		return serial.Mode{}, errors.New("Parity of " + instanceName + " has to be none, odd, even, mark or space")
+	}
+	stopBits, ok := uartStopBits[s.StopBits]
+	if !ok {
+		return serial.Mode{}, errors.New("Stop bits of " + instanceName + " have to be 1, 1.5 or 2")
+	}
+	return serial.Mode{BaudRate: s.Baudrate, DataBits: s.DataBits, Parity: parity, StopBits: stopBits}, nil
+}
 
-	return &GenericUart{
-		site:         site,
-		port:         port,
-		eventChannel: make(chan event.Event, 100),
-	}, nil
+var uartParities = map[string]serial.Parity{
+	"none":  serial.NoParity,
+	"odd":   serial.OddParity,
+	"even":  serial.EvenParity,
+	"mark":  serial.MarkParity,
+	"space": serial.SpaceParity,
 }
 
-func (u *GenericUart) GetEventChannel() chan event.Event {
-	return u.eventChannel
+var uartStopBits = map[float64]serial.StopBits{
+	1:   serial.OneStopBit,
+	1.5: serial.OnePointFiveStopBits,
+	2:   serial.TwoStopBits,
 }
 
-func initPort(addres string, baudrate int) (serial.Port, error) {
-	port, error := serial.Open(addres, &serial.Mode{
-		BaudRate: baudrate,
-	})
-	if error == nil {
-		port.SetReadTimeout(1000)
+func init() {
+	pulseParams := []Param{
+		{Name: "time", Type: ParamInt, Required: true, Min: limit(1), Description: "length of pulse in mS"},
+		{Name: "state", Type: ParamBool, Default: true, Description: "state of line during pulse, opposite state is set afterwards"},
+	}
+	expectedLineParam := func(name string) Param {
+		return Param{Name: name, Type: ParamBool, Description: "expected state of " + strings.ToUpper(name) + ", state is only logged if not set"}
+	}
+	transferParams := []Param{
+		{Name: "file", Type: ParamString, Required: true, Description: "path of the file"},
+		{Name: "protocol", Type: ParamString, Default: "ymodem", Allowed: []string{xmodem.XModem1K.String(), xmodem.YModem.String()}, Description: "transfer protocol"},
+		{Name: "packet_timeout", Type: ParamInt, Default: 10000, Min: limit(1), Description: "time peer has to answer packet in mS"},
+		{Name: "retries", Type: ParamInt, Default: 10, Min: limit(0), Description: "number of times packet is repeated before transfer fails"},
 	}
-	return port, error
+	defaults := GenericUartSettings{
+		SerialLineSettings: SerialLineSettings{Baudrate: 115200, DataBits: 8, Parity: "none", StopBits: 1},
+		ReadTimeout:        1000,
+		ReconnectSettings:  ReconnectSettings{ReconnectInterval: 500, ReconnectMaxInterval: 10000},
+	}
+	RegisterDriver("genericuart", defaults, func(instanceName string, site int, settings GenericUartSettings) (Device, error) {
+		return NewGenericUart(instanceName, site, settings)
+	},
+		Function{Name: "Read", Description: "Reads response from port", Params: slices.Concat([]Param{formatParam}, responseParams)},
+		Function{Name: "Write", Description: "Writes data to port", Params: requestParams},
+		Function{Name: "Send-Receive", Description: "Writes data to port and reads response", Params: slices.Concat(requestParams, responseParams)},
+		Function{Name: "Set-DTR", Description: "Asserts DTR line"},
+		Function{Name: "Clear-DTR", Description: "Clears DTR line"},
+		Function{Name: "Pulse-DTR", Description: "Sets DTR line for given time", Params: pulseParams},
+		Function{Name: "Set-RTS", Description: "Asserts RTS line"},
+		Function{Name: "Clear-RTS", Description: "Clears RTS line"},
+		Function{Name: "Pulse-RTS", Description: "Sets RTS line for given time", Params: pulseParams},
+		Function{Name: "Read-Lines", Description: "Reads state of CTS, DSR and CD lines as 1 or 0", Params: []Param{
+			expectedLineParam("cts"), expectedLineParam("dsr"), expectedLineParam("cd"),
+		}},
+		Function{Name: "Send-File", Description: "Sends file to peer over XMODEM-1K or YMODEM", Params: transferParams},
+		Function{Name: "Receive-File", Description: "Receives file from peer over XMODEM-1K or YMODEM and stores it", Params: transferParams},
+	)
 }
 
-func (u *GenericUart) SequenceEventHandler() {
-	for receivedEvent := range u.eventChannel {
-		sequenceEvent, ok := receivedEvent.Data.(event.SequenceEvent)
-		if !ok || sequenceEvent.DeviceName != "genericuart" || sequenceEvent.Site != u.site {
-			continue
-		}
-		siteResultChannel := receivedEvent.ReturnChannel
-		result := u.functionResolver(sequenceEvent)
-		result.Site = sequenceEvent.Site
-		result.Id = sequenceEvent.Id
-		result.Label = sequenceEvent.Label
-		siteResultChannel <- result
+// Creates UART device from settings of hardware entry - port is opened by Open hook
+func NewGenericUart(instanceName string, site int, settings GenericUartSettings) (*GenericUart, error) {
+	if settings.Address == "" {
+		return nil, errors.New("Unable to parse address for: " + instanceName)
+	}
+	mode, err := settings.mode(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ReadTimeout <= 0 {
+		return nil, errors.New("Read timeout of " + instanceName + " has to be positive")
+	}
+	if err := settings.ReconnectSettings.validate(instanceName); err != nil {
+		return nil, err
+	}
+
+	genericUart := &GenericUart{
+		address:     settings.Address,
+		mode:        mode,
+		readTimeout: time.Duration(settings.ReadTimeout) * time.Millisecond,
+		waitForCTS:  settings.WaitForCTS,
 	}
+	genericUart.deviceBase = newDeviceBase("genericuart", instanceName, site, genericUart.functionResolver)
+	genericUart.connection = newConnection("Port "+settings.Address, genericUart.initPort, settings.ReconnectSettings, genericUart.connectionChanged)
+	return genericUart, nil
 }
 
-func (u *GenericUart) functionResolver(sequenceEvent event.SequenceEvent) test.Result {
-	function, ok := sequenceEvent.StepSettings["function"].(string)
-	if !ok {
-		return test.Result{Result: test.Error, Message: "Error parsing function name"}
+// Opens port with line settings of the device - used on open and on every reconnect
+func (u *GenericUart) initPort() (serial.Port, error) {
+	port, err := serial.Open(u.address, &u.mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := port.SetReadTimeout(u.readTimeout); err != nil {
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// Opens serial port
+func (u *GenericUart) Open() error {
+	return u.connection.Open()
+}
+
+// Drops data left in port buffers by previous run
+func (u *GenericUart) Reset() error {
+	port, err := u.connection.Get()
+	if err != nil {
+		return err
+	}
+	if err := port.ResetInputBuffer(); err != nil {
+		u.connection.Lost(port, err)
+		return err
 	}
+	if err := port.ResetOutputBuffer(); err != nil {
+		u.connection.Lost(port, err)
+		return err
+	}
+	return nil
+}
 
-	switch function {
+// Checks that port is open and still responds. Device file of port given by path disappears when adapter is disconnected,
+// ports given by name (i.e. COM3 on Windows) are checked by reading modem status of open port instead
+func (u *GenericUart) HealthCheck() error {
+	port, err := u.connection.Get()
+	if err != nil {
+		return err
+	}
+	if strings.ContainsRune(u.address, '/') || filepath.IsAbs(u.address) {
+		_, err = os.Stat(u.address)
+	} else {
+		_, err = port.GetModemStatusBits()
+	}
+	if err != nil {
+		u.connection.Lost(port, err)
+		return err
+	}
+	return nil
+}
+
+// Stops event loop, reconnecting and closes serial port
+func (u *GenericUart) Close() error {
+	u.deviceBase.Close()
+	return u.connection.Close()
+}
+
+func (u *GenericUart) functionResolver(sequenceEvent event.SequenceEvent, call Call) test.Result {
+	switch call.Function {
 	case "Read":
-		return u.read(sequenceEvent.StepSettings["threshold"].(string))
+		return u.read(sequenceEvent.GetContext(), call.Params)
 	case "Write":
-		return u.write(sequenceEvent.StepSettings["data"].(string))
+		return u.write(call.Params)
 	case "Send-Receive":
-		return u.sendReceive(sequenceEvent.StepSettings["data"].(string), sequenceEvent.StepSettings["threshold"].(string))
+		return sendReceive(func() test.Result { return u.write(call.Params) }, func() test.Result { return u.read(sequenceEvent.GetContext(), call.Params) }, call.Params)
+	case "Set-DTR", "Clear-DTR":
+		return u.setLine("DTR", call.Function == "Set-DTR")
+	case "Set-RTS", "Clear-RTS":
+		return u.setLine("RTS", call.Function == "Set-RTS")
+	case "Pulse-DTR", "Pulse-RTS":
+		return u.pulseLine(sequenceEvent.GetContext(), strings.TrimPrefix(call.Function, "Pulse-"), call.Params.Bool("state"), time.Duration(call.Params.Int("time"))*time.Millisecond)
+	case "Read-Lines":
+		return u.readLines(call.Params)
+	case "Send-File", "Receive-File":
+		return u.transferFile(sequenceEvent, call)
 	default:
-		return test.Result{Result: test.Error, Message: "Function not found: " + sequenceEvent.Label, Site: sequenceEvent.Site}
+		return test.Result{Result: test.Error, Message: "Function not found: " + call.Function}
 	}
 }
 
-func (u *GenericUart) sendReceive(data, threshold string) test.Result {
-	writeResult := u.write(data)
-	if writeResult.Result == test.Error {
-		return writeResult
-	} else {
-		time.Sleep(time.Millisecond * 20)
-		return u.read(threshold)
+// Reads response until it is complete as described by parameters and checks it
+// Steps of device with lost port result in error right away - port is reopened in background
+func (u *GenericUart) read(stepContext context.Context, params Params) test.Result {
+	spec, err := newResponseSpec(params)
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
 	}
+	port, err := u.connection.Get()
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	response, err := readResponse(stepContext, func(buffer []byte, timeout time.Duration) (int, error) {
+		if err := port.SetReadTimeout(timeout); err != nil {
+			return 0, err
+		}
+		return port.Read(buffer)
+	}, u.readTimeout, spec)
+	if err != nil {
+		if stepContext.Err() == nil {
+			u.connection.Lost(port, err)
+		}
+		return test.Result{Result: test.Error, Message: err.Error() + ", Rx: " + formatPayload(response, spec.binary)}
+	}
+	return spec.check(response)
 }
 
-func (u *GenericUart) read(threshold string) test.Result {
-	buff := make([]byte, 128)
-	n, err := u.port.Read(buff)
+func (u *GenericUart) write(params Params) test.Result {
+	data, err := encodeRequest(params)
 	if err != nil {
 		return test.Result{Result: test.Error, Message: err.Error()}
 	}
-	readBuff := "Rx: " + string(buff[:n])
-	if threshold == "" {
-		return test.Result{Result: test.Done, Message: readBuff}
+	port, err := u.connection.Get()
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	if err := u.waitClearToSend(port); err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
 	}
-	if threshold == string(buff[:n]) {
-		return test.Result{Result: test.Pass, Message: readBuff}
+	_, err = port.Write(data)
+	if err != nil {
+		u.connection.Lost(port, err)
+		return test.Result{Result: test.Error, Message: err.Error()}
 	} else {
-		return test.Result{Result: test.Fail, Message: readBuff}
+		return test.Result{Result: test.Done, Message: "Tx: " + formatPayload(data, binaryRequest(params))}
+	}
+}
+
+// With wait for CTS set write starts only when peer asserts CTS - waits for it up to read timeout
+// CTS is checked once before write, peer dropping it while data is written doesn't pause the write
+func (u *GenericUart) waitClearToSend(port serial.Port) error {
+	if !u.waitForCTS {
+		return nil
+	}
+	deadline := time.Now().Add(u.readTimeout)
+	for {
+		status, err := port.GetModemStatusBits()
+		if err != nil {
+			return errors.New("Unable to read CTS: " + err.Error())
+		}
+		if status.CTS {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("CTS not asserted by peer within read timeout")
+		}
+		time.Sleep(time.Millisecond)
 	}
 }
 
-func (u *GenericUart) write(data string) test.Result {
-	_, err := u.port.Write([]byte(data))
+// Sets DTR or RTS line - modem line errors leave port open, they are usually caused by adapter not supporting the line
+func (u *GenericUart) setLine(line string, state bool) test.Result {
+	port, err := u.connection.Get()
 	if err != nil {
 		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	if line == "DTR" {
+		err = port.SetDTR(state)
 	} else {
-		return test.Result{Result: test.Done, Message: "Tx: " + data}
+		err = port.SetRTS(state)
+	}
+	if err != nil {
+		return test.Result{Result: test.Error, Message: "Unable to set " + line + ": " + err.Error()}
+	}
+	if state {
+		return test.Result{Result: test.Done, Message: line + " set"}
+	}
+	return test.Result{Result: test.Done, Message: line + " cleared"}
+}
+
+// Sets line to given state for given time and to opposite state afterwards, i.e. to reset board or enter bootloader
+// Line is returned to opposite state even when step is cancelled during pulse
+func (u *GenericUart) pulseLine(stepContext context.Context, line string, state bool, duration time.Duration) test.Result {
+	result := u.setLine(line, state)
+	if result.Result == test.Error {
+		return result
+	}
+	completed := sleepContext(stepContext, duration)
+	result = u.setLine(line, !state)
+	if result.Result == test.Error {
+		return result
+	}
+	if !completed {
+		return test.Result{Result: test.Error, Message: line + " pulse cancelled"}
+	}
+	return test.Result{Result: test.Done, Message: fmt.Sprintf("%s pulsed for %v", line, duration)}
+}
+
+// Returns state of CTS, DSR and CD lines as measurements with value 1 or 0 - lines with expected state set are checked
+func (u *GenericUart) readLines(params Params) test.Result {
+	port, err := u.connection.Get()
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	status, err := port.GetModemStatusBits()
+	if err != nil {
+		return test.Result{Result: test.Error, Message: "Unable to read modem lines: " + err.Error()}
+	}
+
+	var measurements []test.Measurement
+	var states []string
+	for _, line := range []struct {
+		name  string
+		state bool
+	}{{"cts", status.CTS}, {"dsr", status.DSR}, {"cd", status.DCD}} {
+		measurement := test.NewMeasurement(strings.ToUpper(line.name), lineValue(line.state))
+		if params.Has(line.name) {
+			measurement.Comparison = test.EQ
+			if params.Bool(line.name) {
+				measurement.Low = 1
+			}
+		}
+		measurements = append(measurements, measurement)
+		states = append(states, strings.ToUpper(line.name)+"="+lineValue(line.state))
+	}
+	return test.Result{Result: test.Done, Message: strings.Join(states, " "), Measurements: measurements}
+}
+
+func lineValue(state bool) string {
+	if state {
+		return "1"
+	}
+	return "0"
+}
+
+// Stream file transfer runs over - reads return quickly so transfer handles its own timeouts, every packet waits
+// for CTS when it is set and first I/O error is kept so port can be marked lost afterwards
+type uartStream struct {
+	uart  *GenericUart
+	port  serial.Port
+	ioErr error
+}
+
+func (s *uartStream) Read(buffer []byte) (int, error) {
+	n, err := s.port.Read(buffer)
+	if err != nil && s.ioErr == nil {
+		s.ioErr = err
+	}
+	return n, err
+}
+
+func (s *uartStream) Write(data []byte) (int, error) {
+	if err := s.uart.waitClearToSend(s.port); err != nil {
+		return 0, err
+	}
+	n, err := s.port.Write(data)
+	if err != nil && s.ioErr == nil {
+		s.ioErr = err
 	}
+	return n, err
 }
 
-func (u *GenericUart) Print() {
-	fmt.Println("GenericUart device created for site: " + fmt.Sprintf("%v", u.site))
+// Sends or receives file over XMODEM-1K or YMODEM - progress is shown in UI while transfer runs
+// Received file is stored under given path, its size is returned as measurement
+func (u *GenericUart) transferFile(sequenceEvent event.SequenceEvent, call Call) test.Result {
+	protocol, ok := xmodem.ParseProtocol(call.Params.String("protocol"))
+	if !ok {
+		return test.Result{Result: test.Error, Message: "Unknown transfer protocol " + call.Params.String("protocol") + ", has to be xmodem1k or ymodem"}
+	}
+	path := call.Params.String("file")
+	sending := call.Function == "Send-File"
+	var data []byte
+	if sending {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return test.Result{Result: test.Error, Message: "Unable to read file: " + err.Error()}
+		}
+	}
+
+	port, err := u.connection.Get()
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	if err := port.SetReadTimeout(50 * time.Millisecond); err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	defer port.SetReadTimeout(u.readTimeout)
+
+	direction := "Receiving "
+	if sending {
+		direction = "Sending "
+	}
+	lastPercent := -1
+	options := xmodem.Options{
+		Protocol:      protocol,
+		PacketTimeout: time.Duration(call.Params.Int("packet_timeout")) * time.Millisecond,
+		Retries:       call.Params.Int("retries"),
+		// Progress is shown in steps of 5% - size of received file is unknown for XMODEM-1K
+		Progress: func(transferred, total int) {
+			if total == 0 {
+				u.notifyProgress(sequenceEvent, fmt.Sprintf("%s%s %v B", direction, filepath.Base(path), transferred))
+				return
+			}
+			if percent := transferred * 100 / total; percent/5 != lastPercent/5 {
+				lastPercent = percent
+				u.notifyProgress(sequenceEvent, fmt.Sprintf("%s%s %v%%", direction, filepath.Base(path), percent))
+			}
+		},
+	}
+
+	stream := &uartStream{uart: u, port: port}
+	var name string
+	if sending {
+		err = xmodem.Send(sequenceEvent.GetContext(), stream, filepath.Base(path), data, options)
+	} else {
+		name, data, err = xmodem.Receive(sequenceEvent.GetContext(), stream, options)
+	}
+	if stream.ioErr != nil {
+		u.connection.Lost(port, stream.ioErr)
+	}
+	// Failed transfer (NAK or timeout after all retries, cancel by peer) fails the step so it can be retried
+	if err != nil {
+		result := test.Result{Result: test.Fail, Message: protocol.String() + " transfer of " + path + " failed: " + err.Error()}
+		if sequenceEvent.GetContext().Err() != nil || stream.ioErr != nil {
+			result.Result = test.Error
+		}
+		return result
+	}
+
+	if sending {
+		return test.Result{Result: test.Done, Message: fmt.Sprintf("Sent %s (%v B) over %s", path, len(data), protocol)}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return test.Result{Result: test.Error, Message: "Unable to store received file: " + err.Error()}
+	}
+	message := fmt.Sprintf("Received %s (%v B) over %s", path, len(data), protocol)
+	if name != "" {
+		message += ", sent as " + name
+	}
+	measurement := test.NewMeasurement("size", fmt.Sprintf("%v", len(data)))
+	measurement.Unit = "B"
+	return test.Result{Result: test.Done, Message: message, Measurements: []test.Measurement{measurement}}
 }
@@ -0,0 +1,197 @@
+//go:build linux
+
+package device
+
+import (
+	"bytes"
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"checkerbox/internal/xmodem"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Master end of pseudo terminal - peer of device opened on its slave end
+// Read returns 0 bytes when nothing arrives within short time, like serial port with read timeout
+type ptyPeer struct {
+	master *os.File
+}
+
+// Opens pseudo terminal pair and returns its master end with path of slave end device opens as serial port
+func openPty(t *testing.T) (*ptyPeer, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("Pseudo terminal not available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Fatalf("Unlocking pseudo terminal failed: %v", err)
+	}
+	var number uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		t.Fatalf("Reading pseudo terminal number failed: %v", err)
+	}
+	return &ptyPeer{master: master}, "/dev/pts/" + strconv.Itoa(int(number))
+}
+
+func ioctl(file *os.File, request uint, argument unsafe.Pointer) error {
+	connection, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := connection.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(request), uintptr(argument))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (p *ptyPeer) Read(buffer []byte) (int, error) {
+	p.master.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	n, err := p.master.Read(buffer)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, nil
+	}
+	return n, err
+}
+
+func (p *ptyPeer) Write(data []byte) (int, error) {
+	return p.master.Write(data)
+}
+
+// Drops everything device wrote so far, i.e. cancel sent by aborted transfer
+func (p *ptyPeer) drain() {
+	buffer := make([]byte, 4096)
+	for {
+		if n, _ := p.Read(buffer); n == 0 {
+			return
+		}
+	}
+}
+
+// Sends step to device with given context and waits for its final result, skipping progress
+func runStepWithContext(t *testing.T, testedDevice Device, stepContext context.Context, stepSettings map[string]any) test.Result {
+	t.Helper()
+	results := make(chan test.Result, 100)
+	testedDevice.GetEventChannel() <- event.Event{
+		Type:          "sequence",
+		ReturnChannel: results,
+		Data: event.SequenceEvent{
+			DeviceName:   testedDevice.GetName(),
+			Site:         testedDevice.GetSite(),
+			StepSettings: stepSettings,
+			Context:      stepContext,
+		},
+	}
+	for {
+		select {
+		case result := <-results:
+			if result.Result != test.InProgress {
+				return result
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Step %v didn't finish", stepSettings)
+		}
+	}
+}
+
+// Checks that port is usable after transfer - response arriving later than short read timeout used by transfer is read
+func expectPortUsable(t *testing.T, uart Device, peer *ptyPeer) {
+	t.Helper()
+	peer.drain()
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		peer.Write([]byte("READY\r\n"))
+	}()
+	start := time.Now()
+	result := runStep(t, uart, map[string]any{"function": "Read", "until": "\n"})
+	if result.Result != test.Done || result.Data != "READY\r\n" {
+		t.Fatalf("Read after transfer failed: %v", result)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Read returned after %v, before response arrived", elapsed)
+	}
+}
+
+func TestGenericUartFileTransfer(t *testing.T) {
+	data := bytes.Repeat([]byte("checkerbox firmware image "), 200)
+	options := xmodem.Options{Protocol: xmodem.YModem, PacketTimeout: 2 * time.Second, Retries: 3}
+
+	t.Run("send", func(t *testing.T) {
+		peer, slave := openPty(t)
+		uart := startDevice(t, "genericuart", map[string]any{"address": slave, "read_timeout": 1000})
+		path := filepath.Join(t.TempDir(), "firmware.bin")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		received := make(chan []byte, 1)
+		go func() {
+			name, receivedData, err := xmodem.Receive(context.Background(), peer, options)
+			if err != nil || name != "firmware.bin" {
+				t.Errorf("Peer receive failed: %q, %v", name, err)
+			}
+			received <- receivedData
+		}()
+
+		result := runStep(t, uart, map[string]any{"function": "Send-File", "file": path})
+		if result.Result != test.Done {
+			t.Fatalf("Send-File failed: %v", result)
+		}
+		if receivedData := <-received; !bytes.Equal(receivedData, data) {
+			t.Fatalf("Peer received %v B differing from %v B sent", len(receivedData), len(data))
+		}
+		expectPortUsable(t, uart, peer)
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		peer, slave := openPty(t)
+		uart := startDevice(t, "genericuart", map[string]any{"address": slave, "read_timeout": 1000})
+		path := filepath.Join(t.TempDir(), "received.bin")
+		go func() {
+			if err := xmodem.Send(context.Background(), peer, "image.bin", data, options); err != nil {
+				t.Errorf("Peer send failed: %v", err)
+			}
+		}()
+
+		result := runStep(t, uart, map[string]any{"function": "Receive-File", "file": path})
+		if result.Result != test.Done || result.MeasurementString() != "size "+strconv.Itoa(len(data))+"B" {
+			t.Fatalf("Receive-File failed: %v", result)
+		}
+		stored, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(stored, data) {
+			t.Fatalf("Stored %v B differing from %v B sent: %v", len(stored), len(data), err)
+		}
+		expectPortUsable(t, uart, peer)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		peer, slave := openPty(t)
+		uart := startDevice(t, "genericuart", map[string]any{"address": slave, "read_timeout": 1000})
+		// Peer never starts sending - step is cancelled while device waits for it
+		stepContext, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		result := runStepWithContext(t, uart, stepContext, map[string]any{"function": "Receive-File", "file": filepath.Join(t.TempDir(), "never.bin")})
+		if result.Result != test.Error {
+			t.Fatalf("Expected cancelled transfer error, got %v", result)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Cancelled transfer took %v", elapsed)
+		}
+		expectPortUsable(t, uart, peer)
+	})
+}
@@ -0,0 +1,314 @@
+package device
+
+import (
+	"bytes"
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Driver describes device type that can be used in config - name used in hardware section and functions it supports
+type Driver struct {
+	Name      string
+	Functions []Function
+	// Creates device from settings section of hardware entry, nil for devices created by application itself
+	factory func(name string, site int, settings map[string]any) (Device, error)
+	// Decodes single field of settings section into settings type of the driver
+	decodeField func(key string, value any) error
+}
+
+var drivers = make(map[string]Driver)
+
+// Registers device driver - meant to be called from init function of the file implementing device
+// Settings section of hardware entry is decoded into settings type T, starting from provided defaults
+// Factory receives instance name which device has to handle sequence events for. It only checks settings and builds
+// device - resources are opened by Open hook, so factory is also used to validate config
+func RegisterDriver[T any](name string, defaults T, factory func(instanceName string, site int, settings T) (Device, error), functions ...Function) {
+	if _, ok := drivers[name]; ok {
+		panic("Device driver registered twice: " + name)
+	}
+	drivers[name] = Driver{
+		Name:      name,
+		Functions: functions,
+		factory: func(instanceName string, site int, settingsMap map[string]any) (Device, error) {
+			settings := defaults
+			if err := decodeSettings(settingsMap, &settings); err != nil {
+				return nil, errors.New("Invalid settings for " + instanceName + ": " + err.Error())
+			}
+			return factory(instanceName, site, settings)
+		},
+		decodeField: func(key string, value any) error {
+			settings := defaults
+			return decodeSettings(map[string]any{key: value}, &settings)
+		},
+	}
+}
+
+// Registers driver of device created by application itself - it can't be declared in hardware section
+func registerBuiltinDriver(name string, functions ...Function) {
+	drivers[name] = Driver{
+		Name:      name,
+		Functions: functions,
+	}
+}
+
+// Decodes settings map from config into typed settings structure, rejecting unknown fields
+func decodeSettings(settingsMap map[string]any, settings any) error {
+	if len(settingsMap) == 0 {
+		return nil
+	}
+	encoded, err := yaml.Marshal(settingsMap)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(encoded))
+	decoder.KnownFields(true)
+	return decoder.Decode(settings)
+}
+
+// Creates device instance of given driver for site with settings from hardware section of config
+func NewDevice(driverName, instanceName string, site int, settings map[string]any) (Device, error) {
+	driver, ok := drivers[driverName]
+	if !ok {
+		return nil, errors.New("Specified device not supported: " + driverName)
+	}
+	if driver.factory == nil {
+		return nil, errors.New("Device " + driverName + " is created automatically and can't be declared in hardware section")
+	}
+	return driver.factory(instanceName, site, settings)
+}
+
+// Checks single field of settings section of hardware entry - unknown field or value of wrong type is an error
+func (d Driver) CheckSetting(key string, value any) error {
+	if d.decodeField == nil {
+		return errors.New("Device " + d.Name + " has no settings")
+	}
+	return d.decodeField(key, value)
+}
+
+// Checks settings section of hardware entry the same way device is created from it, without opening any resources
+func (d Driver) CheckSettings(instanceName string, site int, settings map[string]any) error {
+	if d.factory == nil {
+		return errors.New("Device " + d.Name + " is created automatically and can't be declared in hardware section")
+	}
+	checkedDevice, err := d.factory(instanceName, site, settings)
+	if err != nil {
+		return err
+	}
+	checkedDevice.Close()
+	return nil
+}
+
+// Returns driver registered under given name
+func GetDriver(name string) (Driver, bool) {
+	driver, ok := drivers[name]
+	return driver, ok
+}
+
+// Returns all registered drivers sorted by name
+func GetDrivers() []Driver {
+	var driverList []Driver
+	for _, name := range slices.Sorted(maps.Keys(drivers)) {
+		driverList = append(driverList, drivers[name])
+	}
+	return driverList
+}
+
+// Checks if driver is used by device created by application itself, which can't be declared in hardware section
+func (d Driver) IsBuiltin() bool {
+	return d.factory == nil
+}
+
+// Returns declaration of function with given name if driver supports it
+func (d Driver) GetFunction(name string) (Function, bool) {
+	for _, function := range d.Functions {
+		if function.Name == name {
+			return function, true
+		}
+	}
+	return Function{}, false
+}
+
+// Returns names of all functions supported by driver
+func (d Driver) GetFunctionNames() []string {
+	var names []string
+	for _, function := range d.Functions {
+		names = append(names, function.Name)
+	}
+	return names
+}
+
+// Event loop shared by all devices - device embeds it and provides function resolver
+// Events are routed to it by dispatcher - ones addressed to other device instance or site are still ignored
+// Stamps results and sends them to channel provided in event
+// Step settings are decoded into parameters declared by driver function before resolver is called
+// Steps of device marked unavailable by failed health check result in error without calling resolver
+type deviceBase struct {
+	eventChannel  chan event.Event
+	returnChannel chan test.Result
+	driver        string
+	name          string
+	site          int
+	resolver      func(event.SequenceEvent, Call) test.Result
+	availability  *availability
+	statusChannel chan<- StatusEvent
+}
+
+// Reason why device is unavailable, nil if device is available - set by main loop, read by event loop
+type availability struct {
+	mutex sync.Mutex
+	err   error
+}
+
+func newDeviceBase(driver, name string, site int, resolver func(event.SequenceEvent, Call) test.Result) deviceBase {
+	return deviceBase{
+		eventChannel: make(chan event.Event, 100),
+		driver:       driver,
+		name:         name,
+		site:         site,
+		resolver:     resolver,
+		availability: &availability{},
+	}
+}
+
+func (b *deviceBase) SequenceEventHandler() {
+	for receivedEvent := range b.eventChannel {
+		sequenceEvent, ok := receivedEvent.Data.(event.SequenceEvent)
+		if !ok || sequenceEvent.DeviceName != b.name || sequenceEvent.Site != b.site {
+			continue
+		}
+
+		siteResultChannel := receivedEvent.ReturnChannel
+		b.returnChannel = siteResultChannel
+		var result test.Result
+		call, err := b.decodeCall(sequenceEvent.StepSettings)
+		if err != nil {
+			result = test.Result{Result: test.Error, Message: err.Error()}
+		} else if err := b.unavailable(); err != nil {
+			result = test.Result{Result: test.Error, Message: "Device " + b.name + " unavailable: " + err.Error()}
+		} else if err := sequenceEvent.GetContext().Err(); err != nil {
+			// Step could have been cancelled while waiting in device queue
+			result = test.Result{Result: test.Error, Message: "Step cancelled before execution"}
+		} else {
+			result = b.resolver(sequenceEvent, call)
+		}
+		result.Stage = sequenceEvent.Stage
+		result.Site = sequenceEvent.Site
+		result.Id = sequenceEvent.Id
+		result.Label = sequenceEvent.Label
+		result.Dispatch = sequenceEvent.Dispatch
+		siteResultChannel <- result
+	}
+}
+
+// Resolves function of step settings in driver declaration and decodes its parameters
+func (b *deviceBase) decodeCall(stepSettings map[string]any) (Call, error) {
+	functionName, ok := stepSettings["function"].(string)
+	if !ok {
+		return Call{}, errors.New("Error parsing function name")
+	}
+	driver, _ := GetDriver(b.driver)
+	function, ok := driver.GetFunction(functionName)
+	if !ok {
+		return Call{}, errors.New("Function " + functionName + " not supported by " + b.name)
+	}
+	params, paramErrors := function.Decode(stepSettings)
+	if len(paramErrors) > 0 {
+		var messages []string
+		for _, paramError := range paramErrors {
+			messages = append(messages, paramError.Error())
+		}
+		return Call{}, errors.New(strings.Join(messages, "; "))
+	}
+	return Call{Function: functionName, Params: params}, nil
+}
+
+func (b *deviceBase) setAvailability(err error) {
+	b.availability.mutex.Lock()
+	defer b.availability.mutex.Unlock()
+	b.availability.err = err
+}
+
+// Sends intermediate result showing progress of long running step, i.e. file transfer - it doesn't finish the step
+// Progress is dropped when result channel is full
+func (b *deviceBase) notifyProgress(sequenceEvent event.SequenceEvent, message string) {
+	select {
+	case b.returnChannel <- test.Result{Site: sequenceEvent.Site, Id: sequenceEvent.Id, Label: sequenceEvent.Label, Result: test.InProgress, Message: message, Dispatch: sequenceEvent.Dispatch}:
+	default:
+	}
+}
+
+func (b *deviceBase) SetStatusChannel(statusChannel chan<- StatusEvent) {
+	b.statusChannel = statusChannel
+}
+
+// Reports status change of the device - dropped if nobody listens for it or channel is full
+func (b *deviceBase) publishStatus(available bool, message string) {
+	if b.statusChannel == nil {
+		return
+	}
+	select {
+	case b.statusChannel <- StatusEvent{Site: b.site, Name: b.name, Available: available, Message: message}:
+	default:
+	}
+}
+
+// Reports lost and restored connection - restored connection also makes device available again, steps addressed
+// to device while connection is down result in error of the connection itself
+func (b *deviceBase) connectionChanged(available bool, message string) {
+	if available {
+		b.setAvailability(nil)
+	}
+	b.publishStatus(available, message)
+}
+
+func (b *deviceBase) unavailable() error {
+	b.availability.mutex.Lock()
+	defer b.availability.mutex.Unlock()
+	return b.availability.err
+}
+
+// Devices without resources to open have nothing to do
+func (b *deviceBase) Open() error {
+	return nil
+}
+
+// Devices without state kept between runs have nothing to reset
+func (b *deviceBase) Reset() error {
+	return nil
+}
+
+// Devices without resources that could fail are always healthy
+func (b *deviceBase) HealthCheck() error {
+	return nil
+}
+
+// Closes event channel which ends event loop - device can't receive events afterwards
+// Devices holding resources override it and close them as well
+func (b *deviceBase) Close() error {
+	close(b.eventChannel)
+	return nil
+}
+
+func (b *deviceBase) GetEventChannel() chan event.Event {
+	return b.eventChannel
+}
+
+func (b *deviceBase) GetName() string {
+	return b.name
+}
+
+func (b *deviceBase) GetSite() int {
+	return b.site
+}
+
+func (b *deviceBase) Print() {
+	fmt.Println(b.name + " device at site: " + fmt.Sprintf("%v", b.site))
+}
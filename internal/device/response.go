@@ -0,0 +1,227 @@
+package device
+
+import (
+	"bytes"
+	"checkerbox/internal/test"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Parameters of functions reading response from text stream - shared by devices talking to line based consoles
+var responseParams = []Param{
+	{Name: "threshold", Type: ParamString, Description: "expected response, response is only logged if not set"},
+	{Name: "until", Type: ParamString, Description: "terminator or prompt response ends with, error if not received"},
+	{Name: "count", Type: ParamInt, Min: limit(1), Description: "number of bytes response has, error if not received"},
+	{Name: "expect", Type: ParamString, Description: "prompt response ends with, fail if not received"},
+	{Name: "contains", Type: ParamString, Description: "text response has to contain"},
+	{Name: "regex", Type: ParamString, Description: "regex response has to match, fail if not received, first group is returned as measurement"},
+	{Name: "timeout", Type: ParamInt, Min: limit(1), Description: "time framed response is read for in mS, until line is idle if not set"},
+	{Name: "expected", Type: ParamString, Description: "expected response data written in format of the call"},
+	{Name: "response_frame", Type: ParamString, Description: "frame template response is received in, data is checked and matched"},
+}
+
+// Describes where response read from stream ends and how it is checked
+// Response framed by until, count, expect, regex, frame with length field or expected text ends when it is complete
+// or timeout passes. Response without timeout and unframed response end when line stays idle - first byte is waited
+// for read timeout of the device, response ends at first gap between bytes longer than response gap
+// Response in frame is checked against frame template and matching is done on data it holds
+type responseSpec struct {
+	threshold string
+	until     string
+	count     int
+	expect    string
+	contains  string
+	regex     *regexp.Regexp
+	timeout   time.Duration
+	expected  []byte
+	frame     *frameTemplate
+	binary    bool
+}
+
+// Builds response spec from decoded parameters of the call
+func newResponseSpec(params Params) (responseSpec, error) {
+	format := params.String("format")
+	if format == "" {
+		format = "text"
+	}
+	spec := responseSpec{
+		threshold: params.String("threshold"),
+		until:     params.String("until"),
+		count:     params.Int("count"),
+		expect:    params.String("expect"),
+		contains:  params.String("contains"),
+		timeout:   time.Duration(params.Int("timeout")) * time.Millisecond,
+		binary:    format != "text" || params.Has("response_frame"),
+	}
+	if params.Has("expected") {
+		expected, err := decodePayload(params.String("expected"), format)
+		if err != nil {
+			return spec, err
+		}
+		spec.expected = expected
+	}
+	if params.Has("response_frame") {
+		frame, err := parseFrameTemplate(params.String("response_frame"))
+		if err != nil {
+			return spec, err
+		}
+		spec.frame = frame
+	}
+	if params.Has("regex") {
+		regex, err := regexp.Compile(params.String("regex"))
+		if err != nil {
+			return spec, errors.New("Invalid regex: " + err.Error())
+		}
+		spec.regex = regex
+	}
+	return spec, nil
+}
+
+// Checks if response is framed by terminator, prompt, byte count, regex, frame with length field or expected text
+func (s responseSpec) framed() bool {
+	return s.until != "" || s.count > 0 || s.expect != "" || s.regex != nil || (s.frame != nil && s.frame.selfDelimited()) ||
+		(s.frame == nil && (s.threshold != "" || s.expected != nil))
+}
+
+// Checks if received data holds whole response - terminator, prompt, byte count, regex match, frame and expected text
+// that are set. Frame that doesn't match template is complete as well, there is no point in waiting for more data
+func (s responseSpec) complete(received []byte) bool {
+	if s.frame != nil && s.frame.selfDelimited() {
+		if _, complete, _ := s.frame.parse(received); !complete {
+			return false
+		}
+	}
+	if s.frame == nil && s.threshold != "" && formatPayload(received, s.binary) != s.threshold {
+		return false
+	}
+	if s.frame == nil && s.expected != nil && !bytes.Equal(received, s.expected) {
+		return false
+	}
+	if s.regex != nil && !s.regex.Match(received) {
+		return false
+	}
+	if s.until != "" && !bytes.Contains(received, []byte(s.until)) {
+		return false
+	}
+	if s.expect != "" && !bytes.Contains(received, []byte(s.expect)) {
+		return false
+	}
+	return len(received) >= s.count
+}
+
+// Sends request and reads response to it - reading is skipped when request couldn't be sent
+// Binary request is logged together with response as hex dump
+func sendReceive(send, receive func() test.Result, params Params) test.Result {
+	sendResult := send()
+	if sendResult.Result == test.Error {
+		return sendResult
+	}
+	receiveResult := receive()
+	if binaryRequest(params) {
+		receiveResult.Message = sendResult.Message + " " + receiveResult.Message
+	}
+	return receiveResult
+}
+
+// Longest gap between bytes of one response - response read until line is idle ends when next bytes don't follow within it
+const responseGap = 100 * time.Millisecond
+
+// Reads response using read function, which waits for data at most given time and returns 0 bytes when none arrived
+// First byte is waited for at most first byte timeout, following ones at most response gap
+// Error is returned only when stream failed or step was cancelled - received data is returned together with it
+func readResponse(stepContext context.Context, read func(buffer []byte, timeout time.Duration) (int, error), firstByte time.Duration, spec responseSpec) ([]byte, error) {
+	waitForFrame := spec.framed() && spec.timeout > 0
+	deadline := time.Now().Add(spec.timeout)
+
+	var received []byte
+	// Buffer holds largest UDP datagram, so datagrams are never truncated
+	buffer := make([]byte, 65536)
+	for {
+		if err := stepContext.Err(); err != nil {
+			return received, errors.New("Step cancelled while reading response")
+		}
+		timeout := firstByte
+		if len(received) > 0 {
+			timeout = min(firstByte, responseGap)
+		}
+		if waitForFrame {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return received, nil
+			}
+			timeout = min(remaining, time.Second)
+		}
+
+		n, err := read(buffer, timeout)
+		received = append(received, buffer[:n]...)
+		if err != nil {
+			return received, err
+		}
+		if spec.framed() && spec.complete(received) {
+			return received, nil
+		}
+		if n == 0 && !waitForFrame {
+			// Line stayed idle - response ended
+			return received, nil
+		}
+	}
+}
+
+// Resolves result of read response - missing expected prompt, text, regex match, expected data or invalid frame
+// fails the step, missing terminator or bytes are an error
+// Response is returned as measurement, checked against threshold or expected data. Value matched by regex group
+// is returned as first measurement so it can be checked against limits of the step
+func (s responseSpec) check(response []byte) test.Result {
+	message := "Rx: " + formatPayload(response, s.binary)
+	switch {
+	case s.until != "" && !bytes.Contains(response, []byte(s.until)):
+		return test.Result{Result: test.Error, Message: "Terminator " + strconv.Quote(s.until) + " not received, " + message}
+	case s.count > 0 && len(response) < s.count:
+		return test.Result{Result: test.Error, Message: fmt.Sprintf("Received %v of %v bytes, %s", len(response), s.count, message)}
+	case s.expect != "" && !bytes.Contains(response, []byte(s.expect)):
+		return test.Result{Result: test.Fail, Message: "Prompt " + strconv.Quote(s.expect) + " not received, " + message}
+	}
+
+	data := response
+	if s.frame != nil {
+		frameData, complete, err := s.frame.parse(response)
+		if err == nil && !complete {
+			err = errors.New("frame not complete")
+		}
+		if err != nil {
+			return test.Result{Result: test.Fail, Message: "Invalid response frame: " + err.Error() + ", " + message}
+		}
+		data = frameData
+	}
+
+	var measurements []test.Measurement
+	if s.contains != "" && !bytes.Contains(data, []byte(s.contains)) {
+		return test.Result{Result: test.Fail, Message: message + " does not contain " + strconv.Quote(s.contains)}
+	}
+	if s.regex != nil {
+		match := s.regex.FindSubmatch(data)
+		if match == nil {
+			return test.Result{Result: test.Fail, Message: message + " does not match " + s.regex.String()}
+		}
+		if len(match) > 1 {
+			measurements = append(measurements, test.NewMeasurement(s.regex.SubexpNames()[1], string(match[1])))
+		}
+	}
+
+	// Response is checked centrally with other limits - when threshold or expected data is set it is expected text
+	measurement := test.NewMeasurement("", formatPayload(data, s.binary))
+	if s.threshold != "" {
+		measurement.Expected = s.threshold
+		measurement.Comparison = test.EQ
+	}
+	if s.expected != nil {
+		measurement.Expected = formatPayload(s.expected, s.binary)
+		measurement.Comparison = test.EQ
+	}
+	measurements = append(measurements, measurement)
+	return test.Result{Result: test.Done, Message: message, Data: measurement.Text, Measurements: measurements}
+}
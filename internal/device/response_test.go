@@ -0,0 +1,127 @@
+package device
+
+import (
+	"checkerbox/internal/test"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Read function returning given chunks one per read - once they run out it reports idle line after waiting
+// given timeout (at most 10mS, so tests of idle line don't take long)
+func chunkReader(chunks ...string) (func(buffer []byte, timeout time.Duration) (int, error), *int) {
+	reads := 0
+	return func(buffer []byte, timeout time.Duration) (int, error) {
+		reads++
+		if len(chunks) == 0 {
+			time.Sleep(min(timeout, 10*time.Millisecond))
+			return 0, nil
+		}
+		n := copy(buffer, chunks[0])
+		chunks = chunks[1:]
+		return n, nil
+	}, &reads
+}
+
+func TestReadResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+		chunks []string
+		// Response returned by read and number of reads it took - read after complete response isn't expected
+		expected string
+		reads    int
+	}{
+		{"until line is idle", Params{}, []string{"AB", "CD"}, "ABCD", 3},
+		{"until terminator", Params{"until": "\r\n"}, []string{"OK", "\r", "\nNEXT"}, "OK\r\nNEXT", 3},
+		{"until prompt", Params{"expect": "> "}, []string{"PONG\r\n", "> "}, "PONG\r\n> ", 2},
+		{"byte count", Params{"count": 3}, []string{"A", "BC", "D"}, "ABC", 2},
+		{"regex match", Params{"regex": `V=(\d+)\s`}, []string{"V=1", "2 ", "rest"}, "V=12 ", 2},
+		{"threshold", Params{"threshold": "OK"}, []string{"O", "K", "!"}, "OK", 2},
+		{"expected hex", Params{"expected": "01 02", "format": "hex"}, []string{"\x01", "\x02"}, "\x01\x02", 2},
+		{"frame with length", Params{"response_frame": "AA {len} {data}"}, []string{"\xAA\x02", "\x01\x02", "\x03"}, "\xAA\x02\x01\x02", 2},
+		{"frame without length", Params{"response_frame": "{data} {sum8}"}, []string{"\x01", "\x01"}, "\x01\x01", 3},
+		{"framed with timeout", Params{"until": "\n", "timeout": 50}, []string{"A", "", "", "B\n"}, "AB\n", 4},
+		{"framed response timed out", Params{"until": "\n", "timeout": 30}, []string{"A"}, "A", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := newResponseSpec(tt.params)
+			if err != nil {
+				t.Fatalf("Creating response spec failed: %v", err)
+			}
+			read, reads := chunkReader(tt.chunks...)
+			response, err := readResponse(context.Background(), read, 20*time.Millisecond, spec)
+			if err != nil {
+				t.Fatalf("Reading response failed: %v", err)
+			}
+			if string(response) != tt.expected {
+				t.Errorf("Read %q, expected %q", response, tt.expected)
+			}
+			if tt.reads > 0 && *reads != tt.reads {
+				t.Errorf("Response took %v reads, expected %v", *reads, tt.reads)
+			}
+		})
+	}
+}
+
+func TestReadResponseErrors(t *testing.T) {
+	t.Run("cancelled", func(t *testing.T) {
+		stepContext, cancel := context.WithCancel(context.Background())
+		cancel()
+		read, _ := chunkReader("A")
+		if _, err := readResponse(stepContext, read, time.Second, responseSpec{}); err == nil || err.Error() != "Step cancelled while reading response" {
+			t.Fatalf("Expected cancel error, got %v", err)
+		}
+	})
+	t.Run("stream failed", func(t *testing.T) {
+		read := func(buffer []byte, timeout time.Duration) (int, error) {
+			return copy(buffer, "AB"), errors.New("connection reset")
+		}
+		response, err := readResponse(context.Background(), read, time.Second, responseSpec{})
+		if err == nil || string(response) != "AB" {
+			t.Fatalf("Expected error with received data, got %q, %v", response, err)
+		}
+	})
+}
+
+func TestResponseCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		params       Params
+		response     string
+		expected     test.ResultType
+		message      string
+		measurements string
+	}{
+		{"logged", Params{}, "OK", test.Done, "Rx: OK", "OK"},
+		{"terminator received", Params{"until": "\n"}, "OK\n", test.Done, "Rx: OK\n", "OK"},
+		{"terminator missing", Params{"until": "\n"}, "OK", test.Error, `Terminator "\n" not received, Rx: OK`, ""},
+		{"bytes missing", Params{"count": 4}, "ABC", test.Error, "Received 3 of 4 bytes, Rx: ABC", ""},
+		{"prompt missing", Params{"expect": "> "}, "OK", test.Fail, `Prompt "> " not received, Rx: OK`, ""},
+		{"contains", Params{"contains": "ERR"}, "OK", test.Fail, `Rx: OK does not contain "ERR"`, ""},
+		{"regex missing", Params{"regex": `V=(\d+)`}, "V=x", test.Fail, `Rx: V=x does not match V=(\d+)`, ""},
+		{"regex group", Params{"regex": `V=(?P<volt>\d+)`}, "V=12", test.Done, "Rx: V=12", "volt 12, V=12"},
+		{"threshold", Params{"threshold": "OK"}, "OK", test.Done, "Rx: OK", "OK EQ[OK] Done"},
+		{"expected hex", Params{"expected": "01 02", "format": "hex"}, "\x01\x03", test.Done, "Rx: [01 03]", "[01 03] EQ[[01 02]] Done"},
+		{"frame data", Params{"response_frame": "AA {len} {data}", "format": "hex", "expected": "05"}, "\xAA\x01\x05", test.Done, "Rx: [AA 01 05]", "[05] EQ[[05]] Done"},
+		{"invalid frame", Params{"response_frame": "AA {len} {data}"}, "\xBB\x01\x05", test.Fail, "Invalid response frame: expected [AA] at offset 0, received [BB], Rx: [BB 01 05]", ""},
+		{"incomplete frame", Params{"response_frame": "AA {len} {data}"}, "\xAA\x02\x05", test.Fail, "Invalid response frame: frame not complete, Rx: [AA 02 05]", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := newResponseSpec(tt.params)
+			if err != nil {
+				t.Fatalf("Creating response spec failed: %v", err)
+			}
+			result := spec.check([]byte(tt.response))
+			if result.Result != tt.expected || result.Message != tt.message {
+				t.Errorf("Got %s %q, expected %s %q", result.Result, result.Message, tt.expected, tt.message)
+			}
+			if measurements := result.MeasurementString(); measurements != tt.measurements {
+				t.Errorf("Measurements %q, expected %q", measurements, tt.measurements)
+			}
+		})
+	}
+}
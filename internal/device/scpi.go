@@ -0,0 +1,406 @@
+package device
+
+import (
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Scpi device controls bench instruments (DMMs, power supplies, electronic loads) over raw TCP socket or serial port
+// Commands and replies are terminated by newline, instrument error queue is checked after every command
+type Scpi struct {
+	deviceBase
+	address        string
+	host           string
+	mode           serial.Mode
+	connectTimeout time.Duration
+	timeout        time.Duration
+	checkErrors    bool
+	connection     *connection[scpiLink]
+	// Serializes commands - identity is queried after reconnect besides event loop of the device
+	io sync.Mutex
+	// Bytes received after terminator of last reply, dropped with other pending replies before next command
+	pending []byte
+	// Guards identity, which is read when site run starts
+	mutex    sync.Mutex
+	identity string
+}
+
+// Settings from hardware section of config - instrument is reached over TCP when host is set (port defaults to 5025),
+// or over serial port at address (defaults to 9600 8N1). Timeout is time instrument has to reply in, times are in mS
+type ScpiSettings struct {
+	Host               string `yaml:"host"`
+	Port               int    `yaml:"port"`
+	Address            string `yaml:"address"`
+	SerialLineSettings `yaml:",inline"`
+	ConnectTimeout     int  `yaml:"connect_timeout"`
+	Timeout            int  `yaml:"timeout"`
+	CheckErrors        bool `yaml:"check_errors"`
+	ReconnectSettings  `yaml:",inline"`
+}
+
+// Maximum number of entries read from error queue after one command - queue of instrument that keeps reporting errors is never empty
+const scpiMaxErrors = 20
+
+// Entry of instrument error queue, i.e. -113,"Undefined header"
+var scpiErrorEntry = regexp.MustCompile(`^([+-]?[0-9]+)\s*,`)
+
+func init() {
+	commandParam := Param{Name: "command", Type: ParamString, Required: true, Description: "SCPI command, newline is appended"}
+	timeoutParam := Param{Name: "timeout", Type: ParamInt, Min: limit(1), Description: "time instrument has to reply in mS, timeout of the device if not set"}
+	checkErrorsParam := Param{Name: "check_errors", Type: ParamBool, Description: "checks error queue after command, check_errors setting of the device if not set"}
+	defaults := ScpiSettings{
+		Port:               5025,
+		SerialLineSettings: SerialLineSettings{Baudrate: 9600, DataBits: 8, Parity: "none", StopBits: 1},
+		ConnectTimeout:     5000,
+		Timeout:            2000,
+		CheckErrors:        true,
+		ReconnectSettings:  ReconnectSettings{ReconnectInterval: 500, ReconnectMaxInterval: 10000},
+	}
+	RegisterDriver("scpi", defaults, func(instanceName string, site int, settings ScpiSettings) (Device, error) {
+		return NewScpi(instanceName, site, settings)
+	},
+		Function{Name: "Write", Description: "Sends command", Params: []Param{commandParam, timeoutParam, checkErrorsParam}},
+		Function{Name: "Query", Description: "Sends query, numeric reply is returned as measurement, list of numbers as measurement per value", Params: []Param{
+			commandParam, timeoutParam, checkErrorsParam,
+			{Name: "name", Type: ParamString, Description: "name of measurement, comma separated names when reply is list of values"},
+			{Name: "threshold", Type: ParamString, Description: "expected text reply, reply is only logged if not set"},
+		}},
+		Function{Name: "Identify", Description: "Queries *IDN? and returns manufacturer, model, serial and firmware as measurements", Params: []Param{timeoutParam}},
+		Function{Name: "Check-Errors", Description: "Reads error queue, error if it isn't empty", Params: []Param{timeoutParam}},
+	)
+}
+
+// Creates SCPI device from settings of hardware entry - connection is opened by Open hook
+func NewScpi(instanceName string, site int, settings ScpiSettings) (*Scpi, error) {
+	if (settings.Host == "") == (settings.Address == "") {
+		return nil, errors.New("Either host or address has to be set for: " + instanceName)
+	}
+	if settings.Port <= 0 || settings.Port > 65535 {
+		return nil, errors.New("Port of " + instanceName + " has to be between 1 and 65535")
+	}
+	mode, err := settings.mode(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ConnectTimeout <= 0 || settings.Timeout <= 0 {
+		return nil, errors.New("Connect timeout and timeout of " + instanceName + " have to be positive")
+	}
+	if err := settings.ReconnectSettings.validate(instanceName); err != nil {
+		return nil, err
+	}
+
+	scpi := &Scpi{
+		address:        settings.Address,
+		mode:           mode,
+		connectTimeout: time.Duration(settings.ConnectTimeout) * time.Millisecond,
+		timeout:        time.Duration(settings.Timeout) * time.Millisecond,
+		checkErrors:    settings.CheckErrors,
+	}
+	connectionName := "Port " + settings.Address
+	if settings.Host != "" {
+		scpi.host = net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port))
+		connectionName = "Instrument " + scpi.host
+	}
+	scpi.deviceBase = newDeviceBase("scpi", instanceName, site, scpi.functionResolver)
+	scpi.connection = newConnection(connectionName, scpi.dial, settings.ReconnectSettings, scpi.connectionChanged)
+	return scpi, nil
+}
+
+// Connection to instrument - TCP socket or serial port
+type scpiLink interface {
+	io.Closer
+	Write(data []byte) (int, error)
+	// Reads data waiting for it at most given time - returns 0 bytes when none arrived
+	readWithin(buffer []byte, timeout time.Duration) (int, error)
+}
+
+type scpiSocket struct {
+	net.Conn
+}
+
+func (s scpiSocket) readWithin(buffer []byte, timeout time.Duration) (int, error) {
+	s.SetReadDeadline(time.Now().Add(timeout))
+	n, err := s.Read(buffer)
+	if isTimeout(err) {
+		return n, nil
+	}
+	return n, err
+}
+
+type scpiPort struct {
+	serial.Port
+}
+
+func (p scpiPort) readWithin(buffer []byte, timeout time.Duration) (int, error) {
+	if err := p.SetReadTimeout(timeout); err != nil {
+		return 0, err
+	}
+	return p.Read(buffer)
+}
+
+// Connects to instrument - used on open and on every reconnect
+func (s *Scpi) dial() (scpiLink, error) {
+	if s.host != "" {
+		conn, err := net.DialTimeout("tcp", s.host, s.connectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return scpiSocket{conn}, nil
+	}
+	port, err := serial.Open(s.address, &s.mode)
+	if err != nil {
+		return nil, err
+	}
+	return scpiPort{port}, nil
+}
+
+// Connects to instrument and reads its identity - instrument not answering *IDN? fails initialization
+func (s *Scpi) Open() error {
+	if err := s.connection.Open(); err != nil {
+		return err
+	}
+	if err := s.readIdentity(); err != nil {
+		s.connection.Disconnect()
+		return err
+	}
+	return nil
+}
+
+// Returns reply of instrument to *IDN? read when device was opened or reconnected
+func (s *Scpi) Identity() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.identity
+}
+
+// Queries *IDN? and stores reply as identity - identity is cleared when instrument doesn't answer
+func (s *Scpi) readIdentity() error {
+	reply, err := s.query(context.Background(), "*IDN?", s.timeout)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.identity = reply
+	if err != nil {
+		return errors.New("Instrument didn't answer *IDN?: " + err.Error())
+	}
+	return nil
+}
+
+// Identity is read again after reconnect, instrument could have been replaced or updated while it was disconnected
+func (s *Scpi) connectionChanged(available bool, message string) {
+	if available {
+		if err := s.readIdentity(); err != nil {
+			message += ", " + err.Error()
+		}
+	}
+	s.deviceBase.connectionChanged(available, message)
+}
+
+// Drops replies left by previous run and clears status and error queue of the instrument
+func (s *Scpi) Reset() error {
+	return s.send("*CLS")
+}
+
+// Instrument is unhealthy while its connection isn't open
+func (s *Scpi) HealthCheck() error {
+	_, err := s.connection.Get()
+	return err
+}
+
+// Stops event loop, reconnecting and closes connection to instrument
+func (s *Scpi) Close() error {
+	s.deviceBase.Close()
+	return s.connection.Close()
+}
+
+func (s *Scpi) functionResolver(sequenceEvent event.SequenceEvent, call Call) test.Result {
+	timeout := s.timeout
+	if call.Params.Has("timeout") {
+		timeout = time.Duration(call.Params.Int("timeout")) * time.Millisecond
+	}
+	checkErrors := s.checkErrors
+	if call.Params.Has("check_errors") {
+		checkErrors = call.Params.Bool("check_errors")
+	}
+
+	switch call.Function {
+	case "Write":
+		command := call.Params.String("command")
+		if err := s.send(command); err != nil {
+			return test.Result{Result: test.Error, Message: err.Error()}
+		}
+		result := test.Result{Result: test.Done, Message: "Tx: " + command}
+		if checkErrors {
+			return s.withErrors(sequenceEvent.GetContext(), result, timeout)
+		}
+		return result
+	case "Query":
+		return s.measure(sequenceEvent.GetContext(), call.Params, timeout, checkErrors)
+	case "Identify":
+		return s.identify(sequenceEvent.GetContext(), timeout)
+	case "Check-Errors":
+		return s.withErrors(sequenceEvent.GetContext(), test.Result{Result: test.Done, Message: "Error queue empty"}, timeout)
+	default:
+		return test.Result{Result: test.Error, Message: "Function not found: " + call.Function}
+	}
+}
+
+// Sends query and returns reply as measurements - reply that is number or list of numbers is returned as measurement
+// per value, so it can be checked against limits of the step. Any other reply is returned as single text measurement
+func (s *Scpi) measure(stepContext context.Context, params Params, timeout time.Duration, checkErrors bool) test.Result {
+	command := params.String("command")
+	reply, err := s.query(stepContext, command, timeout)
+	if err != nil {
+		result := test.Result{Result: test.Error, Message: err.Error()}
+		if checkErrors && stepContext.Err() == nil {
+			// Error queue usually tells why instrument didn't reply
+			return s.withErrors(stepContext, result, timeout)
+		}
+		return result
+	}
+
+	values := strings.Split(reply, ",")
+	var measurements []test.Measurement
+	for _, value := range values {
+		measurement := test.NewMeasurement("", strings.TrimSpace(value))
+		if !measurement.Numeric {
+			measurements = []test.Measurement{test.NewMeasurement("", reply)}
+			break
+		}
+		measurements = append(measurements, measurement)
+	}
+	if params.Has("name") {
+		for i, name := range strings.Split(params.String("name"), ",") {
+			if i < len(measurements) {
+				measurements[i].Name = strings.TrimSpace(name)
+			}
+		}
+	}
+	if params.Has("threshold") {
+		measurements[0].Expected = params.String("threshold")
+		measurements[0].Comparison = test.EQ
+	}
+	result := test.Result{Result: test.Done, Message: "Tx: " + command + " Rx: " + reply, Data: reply, Measurements: measurements}
+	if checkErrors {
+		return s.withErrors(stepContext, result, timeout)
+	}
+	return result
+}
+
+// Queries identity of the instrument and returns its fields as named measurements
+func (s *Scpi) identify(stepContext context.Context, timeout time.Duration) test.Result {
+	reply, err := s.query(stepContext, "*IDN?", timeout)
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	var measurements []test.Measurement
+	fields := strings.SplitN(reply, ",", 4)
+	for i, name := range []string{"manufacturer", "model", "serial", "firmware"} {
+		value := ""
+		if i < len(fields) {
+			value = strings.TrimSpace(fields[i])
+		}
+		measurement := test.NewMeasurement(name, value)
+		// Serial numbers and firmware versions are compared as text even when they look like numbers
+		measurement.Numeric = false
+		measurements = append(measurements, measurement)
+	}
+	return test.Result{Result: test.Done, Message: reply, Data: reply, Measurements: measurements}
+}
+
+// Reads error queue of the instrument - result is turned into error when queue holds errors
+// Replies that aren't error entries (code and message) are late replies to previous queries and are skipped
+func (s *Scpi) withErrors(stepContext context.Context, result test.Result, timeout time.Duration) test.Result {
+	var instrumentErrors []string
+	for range scpiMaxErrors {
+		reply, err := s.query(stepContext, "SYST:ERR?", timeout)
+		if err != nil {
+			return test.Result{Result: test.Error, Message: "Unable to read error queue: " + err.Error() + ", " + result.Message}
+		}
+		match := scpiErrorEntry.FindStringSubmatch(reply)
+		if match == nil {
+			continue
+		}
+		if code, _ := strconv.Atoi(match[1]); code == 0 {
+			break
+		}
+		instrumentErrors = append(instrumentErrors, reply)
+	}
+	if len(instrumentErrors) == 0 {
+		return result
+	}
+	return test.Result{Result: test.Error, Message: "Instrument error: " + strings.Join(instrumentErrors, "; ") + ", " + result.Message}
+}
+
+// Sends command terminated by newline - replies still pending, i.e. late reply to query that timed out,
+// are dropped first so they aren't taken for reply to this command
+func (s *Scpi) send(command string) error {
+	s.io.Lock()
+	defer s.io.Unlock()
+	return s.sendCommand(command)
+}
+
+func (s *Scpi) sendCommand(command string) error {
+	link, err := s.connection.Get()
+	if err != nil {
+		return err
+	}
+	s.pending = nil
+	buffer := make([]byte, 4096)
+	for {
+		n, err := link.readWithin(buffer, time.Millisecond)
+		if err != nil {
+			s.connection.Lost(link, err)
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if _, err := link.Write([]byte(command + "\n")); err != nil {
+		s.connection.Lost(link, err)
+		return err
+	}
+	return nil
+}
+
+// Sends query and returns its reply without terminator - error if reply doesn't arrive within timeout
+// Reply ends at first terminator, bytes received after it are kept buffered
+func (s *Scpi) query(stepContext context.Context, command string, timeout time.Duration) (string, error) {
+	s.io.Lock()
+	defer s.io.Unlock()
+	if err := s.sendCommand(command); err != nil {
+		return "", err
+	}
+	link, err := s.connection.Get()
+	if err != nil {
+		return "", err
+	}
+	reply, err := readResponse(stepContext, link.readWithin, timeout, responseSpec{until: "\n", timeout: timeout})
+	if err != nil {
+		if stepContext.Err() == nil {
+			s.connection.Lost(link, err)
+		}
+		return "", err
+	}
+	line, rest, terminated := strings.Cut(string(reply), "\n")
+	s.pending = []byte(rest)
+	if !terminated {
+		message := "No reply to " + command + " within " + timeout.String()
+		if len(reply) > 0 {
+			message += ", Rx: " + string(reply)
+		}
+		return "", errors.New(message)
+	}
+	return strings.TrimSpace(line), nil
+}
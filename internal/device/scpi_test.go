@@ -0,0 +1,238 @@
+package device
+
+import (
+	"bufio"
+	"checkerbox/internal/test"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In-process SCPI instrument on TCP socket - replies to queries, unknown commands are put into error queue
+type scpiSimulator struct {
+	port        int
+	mutex       sync.Mutex
+	commands    []string
+	errors      []string
+	identity    string
+	connections []net.Conn
+}
+
+const simulatorIdentity = "ACME,DMM100,SN0042,1.07"
+
+func startScpiSimulator(t *testing.T) *scpiSimulator {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	simulator := &scpiSimulator{port: listener.Addr().(*net.TCPAddr).Port, identity: simulatorIdentity}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			simulator.mutex.Lock()
+			simulator.connections = append(simulator.connections, conn)
+			simulator.mutex.Unlock()
+			go simulator.serve(conn)
+		}
+	}()
+	return simulator
+}
+
+func (s *scpiSimulator) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if reply, ok := s.execute(strings.TrimSpace(line)); ok {
+			conn.Write([]byte(reply + "\n"))
+		}
+	}
+}
+
+// Executes command and returns reply, if command is a query
+func (s *scpiSimulator) execute(command string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.commands = append(s.commands, command)
+	switch {
+	case command == "*IDN?":
+		return s.identity, true
+	case command == "*CLS":
+		s.errors = nil
+	case command == "MEAS:VOLT?":
+		return "+3.30E+00", true
+	case command == "MEAS:ALL?":
+		return "1.5,2.5", true
+	case command == "MEAS:TWICE?":
+		// Reply followed by another line in the same write
+		return "1.5\n2.5", true
+	case command == "SYST:ERR?":
+		if len(s.errors) == 0 {
+			return `+0,"No error"`, true
+		}
+		entry := s.errors[0]
+		s.errors = s.errors[1:]
+		return entry, true
+	case strings.HasPrefix(command, "VOLT "):
+	default:
+		s.errors = append(s.errors, `-113,"Undefined header"`)
+	}
+	return "", false
+}
+
+// Closes connections to instrument and changes its identity, as if instrument was replaced
+func (s *scpiSimulator) replace(identity string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.identity = identity
+	for _, conn := range s.connections {
+		conn.Close()
+	}
+	s.connections = nil
+}
+
+func (s *scpiSimulator) received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func TestScpiIdentifiesOnOpen(t *testing.T) {
+	simulator := startScpiSimulator(t)
+	instrument := startDevice(t, "scpi", map[string]any{"host": "127.0.0.1", "port": simulator.port})
+
+	if commands := simulator.received(); len(commands) == 0 || commands[0] != "*IDN?" {
+		t.Fatalf("Instrument wasn't queried for identity on open: %v", commands)
+	}
+	if identity := instrument.(Identifier).Identity(); identity != simulatorIdentity {
+		t.Fatalf("Unexpected identity %q", identity)
+	}
+
+	result := runStep(t, instrument, map[string]any{"function": "Identify"})
+	if result.Result != test.Done || len(result.Measurements) != 4 || result.Measurements[2].Text != "SN0042" || result.Measurements[2].Numeric {
+		t.Fatalf("Unexpected result of Identify: %v", result)
+	}
+}
+
+func TestScpiQueryMeasurements(t *testing.T) {
+	simulator := startScpiSimulator(t)
+	instrument := startDevice(t, "scpi", map[string]any{"host": "127.0.0.1", "port": simulator.port})
+
+	result := runStep(t, instrument, map[string]any{"function": "Query", "command": "MEAS:VOLT?", "name": "voltage"})
+	if result.Result != test.Done || len(result.Measurements) != 1 {
+		t.Fatalf("Unexpected result of query: %v", result)
+	}
+	measurement := result.Measurements[0]
+	if measurement.Name != "voltage" || !measurement.Numeric || measurement.Value != 3.3 {
+		t.Fatalf("Unexpected measurement: %v", measurement)
+	}
+	limits := []test.Measurement{{Name: "voltage", Low: 3.2, High: 3.4, Comparison: test.GELE}}
+	if err := test.ApplyLimits(&result, limits); err != nil || result.Result != test.Pass {
+		t.Fatalf("Measurement within limits has to pass, got %v", result)
+	}
+
+	result = runStep(t, instrument, map[string]any{"function": "Query", "command": "MEAS:ALL?", "name": "first, second"})
+	if result.Result != test.Done || len(result.Measurements) != 2 || result.Measurements[1].Name != "second" || result.Measurements[1].Value != 2.5 {
+		t.Fatalf("Unexpected result of list query: %v", result)
+	}
+}
+
+func TestScpiReplyEndsAtTerminator(t *testing.T) {
+	simulator := startScpiSimulator(t)
+	instrument := startDevice(t, "scpi", map[string]any{"host": "127.0.0.1", "port": simulator.port, "check_errors": false})
+
+	result := runStep(t, instrument, map[string]any{"function": "Query", "command": "MEAS:TWICE?"})
+	if result.Result != test.Done || result.Data != "1.5" {
+		t.Fatalf("Reply has to end at first terminator, got %v", result)
+	}
+	// Line left after reply isn't taken for reply to next query
+	result = runStep(t, instrument, map[string]any{"function": "Query", "command": "MEAS:VOLT?"})
+	if result.Result != test.Done || result.Data != "+3.30E+00" {
+		t.Fatalf("Unexpected reply to next query: %v", result)
+	}
+}
+
+func TestScpiIdentityRefreshedOnReconnect(t *testing.T) {
+	simulator := startScpiSimulator(t)
+	instrument := startDevice(t, "scpi", map[string]any{"host": "127.0.0.1", "port": simulator.port, "reconnect_interval": 20})
+
+	const replacedIdentity = "ACME,DMM100,SN0043,1.08"
+	simulator.replace(replacedIdentity)
+	// Lost connection is found by next step, instrument is reconnected in background
+	runStep(t, instrument, map[string]any{"function": "Query", "command": "MEAS:VOLT?"})
+	deadline := time.Now().Add(5 * time.Second)
+	for instrument.(Identifier).Identity() != replacedIdentity {
+		if time.Now().After(deadline) {
+			t.Fatalf("Identity not refreshed after reconnect: %q", instrument.(Identifier).Identity())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	result := runStep(t, instrument, map[string]any{"function": "Query", "command": "MEAS:VOLT?"})
+	if result.Result != test.Done {
+		t.Fatalf("Query after reconnect failed: %v", result)
+	}
+}
+
+func TestScpiErrorQueue(t *testing.T) {
+	simulator := startScpiSimulator(t)
+	instrument := startDevice(t, "scpi", map[string]any{"host": "127.0.0.1", "port": simulator.port})
+
+	result := runStep(t, instrument, map[string]any{"function": "Write", "command": "VOLT 5"})
+	if result.Result != test.Done {
+		t.Fatalf("Valid command failed: %v", result)
+	}
+
+	result = runStep(t, instrument, map[string]any{"function": "Write", "command": "BOGUS"})
+	if result.Result != test.Error || !strings.Contains(result.Message, `-113,"Undefined header"`) {
+		t.Fatalf("Instrument error has to turn step into error, got %v", result)
+	}
+
+	// Error queue was emptied by previous step
+	result = runStep(t, instrument, map[string]any{"function": "Query", "command": "MEAS:VOLT?"})
+	if result.Result != test.Done {
+		t.Fatalf("Step after instrument error failed: %v", result)
+	}
+
+	result = runStep(t, instrument, map[string]any{"function": "Write", "command": "BOGUS", "check_errors": false})
+	if result.Result != test.Done {
+		t.Fatalf("Step without error check has to be done, got %v", result)
+	}
+	result = runStep(t, instrument, map[string]any{"function": "Check-Errors"})
+	if result.Result != test.Error {
+		t.Fatalf("Check-Errors has to report queued error, got %v", result)
+	}
+}
+
+func TestScpiOpenWithoutIdentity(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	// Peer accepts connection but never replies
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			bufio.NewReader(conn).ReadString(0)
+		}
+	}()
+	instrument, err := NewDevice("scpi", "scpi", 0, map[string]any{"host": "127.0.0.1", "port": listener.Addr().(*net.TCPAddr).Port, "timeout": 100})
+	if err != nil {
+		t.Fatalf("Creating scpi failed: %v", err)
+	}
+	defer instrument.Close()
+	if err := instrument.Open(); err == nil || !strings.Contains(err.Error(), "*IDN?") {
+		t.Fatalf("Open has to fail without identity, got %v", err)
+	}
+}
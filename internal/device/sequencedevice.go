@@ -3,66 +3,142 @@ package device
 import (
 	"checkerbox/internal/event"
 	"checkerbox/internal/test"
+	"context"
 	"fmt"
 	"math/rand/v2"
 	"time"
 )
 
+// Sequence device is created by application for every site - it provides waits and synchronization between sites
 type SequenceDevice struct {
-	eventChannel chan event.Event
-	site         int
+	deviceBase
+	syncManager *SyncManager
 }
 
-func NewSequenceDevice(site int) *SequenceDevice {
-	return &SequenceDevice{
-		site:         site,
-		eventChannel: make(chan event.Event, 100),
-	}
-}
-
-func (s *SequenceDevice) SequenceEventHandler() {
-	for receivedEvent := range s.eventChannel {
-		sequenceEvent, ok := receivedEvent.Data.(event.SequenceEvent)
-		if !ok || sequenceEvent.DeviceName != "sequence" || sequenceEvent.Site != s.site {
-			continue
-		}
-
-		siteResultChannel := receivedEvent.ReturnChannel
-		result := s.functionResolver(sequenceEvent)
-		result.Site = sequenceEvent.Site
-		result.Id = sequenceEvent.Id
-		result.Label = sequenceEvent.Label
-		siteResultChannel <- result
-	}
-}
+// Name under which sequence device is addressed by steps - it can't be used as instance name of other devices
+const SequenceDeviceName = "sequence"
 
-func (s *SequenceDevice) GetEventChannel() chan event.Event {
-	return s.eventChannel
+func init() {
+	nameParam := Param{Name: "name", Type: ParamString, Description: "name of synchronization primitive, step label if not set"}
+	timeoutParam := Param{Name: "timeout", Type: ParamInt, Min: limit(1), Description: "time site can be blocked in mS, step timeout if not set"}
+	sitesParam := Param{Name: "sites", Type: ParamIntList, Min: limit(0), Description: "sites taking part in barrier, all sites of stage if not set"}
+	registerBuiltinDriver(SequenceDeviceName,
+		Function{Name: "Wait", Description: "Waits given time", Params: []Param{
+			{Name: "time", Type: ParamInt, Required: true, Min: limit(0), Description: "time to wait in mS"},
+		}},
+		Function{Name: "WaitRand", Description: "Waits random time up to 1S multiplied by site number"},
+		Function{Name: "Barrier", Description: "Waits until all sites reach barrier", Params: []Param{nameParam, sitesParam, timeoutParam}},
+		Function{Name: "Rendezvous", Description: "Same as Barrier", Params: []Param{nameParam, sitesParam, timeoutParam}},
+		Function{Name: "Lock", Description: "Takes lock, only one site can hold it at once", Params: []Param{nameParam, timeoutParam}},
+		Function{Name: "Unlock", Description: "Releases lock", Params: []Param{nameParam}},
+		Function{Name: "Acquire", Description: "Takes counted semaphore", Params: []Param{
+			nameParam,
+			{Name: "count", Type: ParamInt, Default: 1, Min: limit(1), Description: "number of sites that can hold semaphore at once"},
+			timeoutParam,
+		}},
+		Function{Name: "Release", Description: "Releases counted semaphore", Params: []Param{nameParam}},
+	)
 }
 
-func (s *SequenceDevice) functionResolver(sequenceEvent event.SequenceEvent) test.Result {
-	function, ok := sequenceEvent.StepSettings["function"].(string)
-	if !ok {
-		return test.Result{Result: test.Error, Message: "Error parsing function name"}
+func NewSequenceDevice(site int, syncManager *SyncManager) *SequenceDevice {
+	sequenceDevice := &SequenceDevice{
+		syncManager: syncManager,
 	}
+	sequenceDevice.deviceBase = newDeviceBase(SequenceDeviceName, SequenceDeviceName, site, sequenceDevice.functionResolver)
+	return sequenceDevice
+}
 
-	switch function {
+func (s *SequenceDevice) functionResolver(sequenceEvent event.SequenceEvent, call Call) test.Result {
+	switch call.Function {
 	case "Wait":
-		data, ok := sequenceEvent.StepSettings["time"].(int)
-		if !ok {
-			return test.Result{Result: test.Error, Message: "Error parsing time to wait"}
+		data := call.Params.Int("time")
+		if !sleepContext(sequenceEvent.GetContext(), time.Duration(data)*time.Millisecond) {
+			return test.Result{Result: test.Error, Message: "Wait cancelled"}
 		}
-		time.Sleep(time.Duration(data) * time.Millisecond)
 		return test.Result{Result: test.Done, Message: "Wait " + fmt.Sprintf("%v", data) + "mS"}
 	case "WaitRand":
 		data := rand.IntN(1001) * sequenceEvent.Site
-		time.Sleep(time.Duration(data) * time.Millisecond)
+		if !sleepContext(sequenceEvent.GetContext(), time.Duration(data)*time.Millisecond) {
+			return test.Result{Result: test.Error, Message: "Wait cancelled"}
+		}
 		return test.Result{Result: test.Done, Message: "Wait " + fmt.Sprintf("%v", data) + "mS"}
+	case "Barrier", "Rendezvous":
+		return s.barrier(sequenceEvent, call.Params)
+	case "Lock":
+		return s.acquire(sequenceEvent, call.Params, "lock "+syncName(sequenceEvent, call.Params), 1)
+	case "Unlock":
+		return s.release("lock " + syncName(sequenceEvent, call.Params))
+	case "Acquire":
+		return s.acquire(sequenceEvent, call.Params, "semaphore "+syncName(sequenceEvent, call.Params), call.Params.Int("count"))
+	case "Release":
+		return s.release("semaphore " + syncName(sequenceEvent, call.Params))
 	default:
-		return test.Result{Result: test.Error, Message: "Function not found: "}
+		return test.Result{Result: test.Error, Message: "Function not found: " + call.Function}
+	}
+}
+
+// Waits until all sites of the stage (or sites listed in "sites" setting) reach barrier with the same name
+func (s *SequenceDevice) barrier(sequenceEvent event.SequenceEvent, params Params) test.Result {
+	name := syncName(sequenceEvent, params)
+	timeout := syncTimeout(sequenceEvent, params)
+	err := s.syncManager.Barrier(sequenceEvent.GetContext(), name, sequenceEvent.Stage, s.site, params.IntList("sites"), timeout, func() { s.notifyWaiting(sequenceEvent, "Waiting on barrier "+name) })
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	return test.Result{Result: test.Done, Message: "Passed barrier " + name}
+}
+
+func (s *SequenceDevice) acquire(sequenceEvent event.SequenceEvent, params Params, name string, count int) test.Result {
+	timeout := syncTimeout(sequenceEvent, params)
+	err := s.syncManager.Acquire(sequenceEvent.GetContext(), name, s.site, count, timeout, func() { s.notifyWaiting(sequenceEvent, "Waiting on "+name) })
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	return test.Result{Result: test.Done, Message: "Acquired " + name}
+}
+
+func (s *SequenceDevice) release(name string) test.Result {
+	err := s.syncManager.Release(name, s.site)
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	return test.Result{Result: test.Done, Message: "Released " + name}
+}
+
+// Sends intermediate result so main loop can show that site is blocked
+func (s *SequenceDevice) notifyWaiting(sequenceEvent event.SequenceEvent, message string) {
+	s.returnChannel <- test.Result{
+		Site:     sequenceEvent.Site,
+		Id:       sequenceEvent.Id,
+		Label:    sequenceEvent.Label,
+		Result:   test.Waiting,
+		Message:  message,
+		Dispatch: sequenceEvent.Dispatch,
 	}
 }
 
-func (s *SequenceDevice) Print() {
-	fmt.Println("Sequence device at site: " + fmt.Sprintf("%v", s.site))
+// Name of synchronization primitive - "name" setting or step label if not specified
+func syncName(sequenceEvent event.SequenceEvent, params Params) string {
+	if !params.Has("name") {
+		return sequenceEvent.Label
+	}
+	return params.String("name")
+}
+
+// Time site can be blocked on synchronization step - "timeout" setting in mS or step timeout if not specified
+func syncTimeout(sequenceEvent event.SequenceEvent, params Params) time.Duration {
+	if !params.Has("timeout") {
+		return time.Duration(sequenceEvent.Timeout) * time.Millisecond
+	}
+	return time.Duration(params.Int("timeout")) * time.Millisecond
+}
+
+// Sleeps for given duration - returns false if step context was cancelled before time passed
+func sleepContext(stepContext context.Context, duration time.Duration) bool {
+	select {
+	case <-time.After(duration):
+		return true
+	case <-stepContext.Done():
+		return false
+	}
 }
@@ -0,0 +1,219 @@
+package device
+
+import (
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Socket device talks to DUT or test jig over TCP or UDP - line based consoles and datagram protocols
+type Socket struct {
+	deviceBase
+	address        string
+	protocol       string
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	autoConnect    bool
+	connection     *connection[net.Conn]
+}
+
+// Settings from hardware section of config - protocol is tcp (default) or udp, times are in mS
+// Socket is connected when device is opened unless auto connect is disabled, then it is connected by Connect function
+type SocketSettings struct {
+	Host              string `yaml:"host"`
+	Port              int    `yaml:"port"`
+	Protocol          string `yaml:"protocol"`
+	AutoConnect       bool   `yaml:"auto_connect"`
+	ConnectTimeout    int    `yaml:"connect_timeout"`
+	ReadTimeout       int    `yaml:"read_timeout"`
+	ReconnectSettings `yaml:",inline"`
+}
+
+func init() {
+	expectRegexParams := slices.Clone(responseParams)
+	for i := range expectRegexParams {
+		if expectRegexParams[i].Name == "regex" {
+			expectRegexParams[i].Required = true
+		}
+	}
+	defaults := SocketSettings{
+		Protocol:          "tcp",
+		AutoConnect:       true,
+		ConnectTimeout:    5000,
+		ReadTimeout:       1000,
+		ReconnectSettings: ReconnectSettings{ReconnectInterval: 500, ReconnectMaxInterval: 10000},
+	}
+	RegisterDriver("socket", defaults, func(instanceName string, site int, settings SocketSettings) (Device, error) {
+		return NewSocket(instanceName, site, settings)
+	},
+		Function{Name: "Connect", Description: "Connects socket, if it isn't connected already"},
+		Function{Name: "Send", Description: "Sends data", Params: requestParams},
+		Function{Name: "Receive-Until", Description: "Receives response", Params: slices.Concat([]Param{formatParam}, responseParams)},
+		Function{Name: "Send-Receive", Description: "Sends data and receives response", Params: slices.Concat(requestParams, responseParams)},
+		Function{Name: "Expect-Regex", Description: "Receives response until it matches regex", Params: slices.Concat([]Param{formatParam}, expectRegexParams)},
+		Function{Name: "Close", Description: "Closes socket, it isn't reconnected until Connect"},
+	)
+}
+
+// Creates socket device from settings of hardware entry - socket is connected by Open hook
+func NewSocket(instanceName string, site int, settings SocketSettings) (*Socket, error) {
+	if settings.Host == "" {
+		return nil, errors.New("Unable to parse host for: " + instanceName)
+	}
+	if settings.Port <= 0 || settings.Port > 65535 {
+		return nil, errors.New("Port of " + instanceName + " has to be between 1 and 65535")
+	}
+	protocol := strings.ToLower(settings.Protocol)
+	if protocol != "tcp" && protocol != "udp" {
+		return nil, errors.New("Protocol of " + instanceName + " has to be tcp or udp")
+	}
+	if settings.ConnectTimeout <= 0 || settings.ReadTimeout <= 0 {
+		return nil, errors.New("Connect and read timeout of " + instanceName + " have to be positive")
+	}
+	if err := settings.ReconnectSettings.validate(instanceName); err != nil {
+		return nil, err
+	}
+
+	socket := &Socket{
+		address:        net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port)),
+		protocol:       protocol,
+		connectTimeout: time.Duration(settings.ConnectTimeout) * time.Millisecond,
+		readTimeout:    time.Duration(settings.ReadTimeout) * time.Millisecond,
+		autoConnect:    settings.AutoConnect,
+	}
+	socket.deviceBase = newDeviceBase("socket", instanceName, site, socket.functionResolver)
+	socket.connection = newConnection(strings.ToUpper(protocol)+" socket "+socket.address, socket.dial, settings.ReconnectSettings, socket.connectionChanged)
+	return socket, nil
+}
+
+// Connects socket - used on open, by Connect function and on every reconnect
+func (s *Socket) dial() (net.Conn, error) {
+	return net.DialTimeout(s.protocol, s.address, s.connectTimeout)
+}
+
+// Connects socket unless auto connect is disabled
+func (s *Socket) Open() error {
+	if !s.autoConnect {
+		return nil
+	}
+	return s.connection.Open()
+}
+
+// Connects auto connected socket closed by previous run and drops data left in socket
+func (s *Socket) Reset() error {
+	if s.autoConnect {
+		if err := s.connection.Open(); err != nil {
+			return err
+		}
+	}
+	conn, err := s.connection.Get()
+	if err != nil {
+		// Socket is connected by Connect function
+		return nil
+	}
+	buffer := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		if _, err := conn.Read(buffer); err != nil {
+			if isTimeout(err) {
+				return nil
+			}
+			s.connection.Lost(conn, err)
+			return err
+		}
+	}
+}
+
+// Socket is unhealthy only while its lost connection isn't restored - socket closed on request can be connected again
+func (s *Socket) HealthCheck() error {
+	if !s.connection.IsLost() {
+		return nil
+	}
+	_, err := s.connection.Get()
+	return err
+}
+
+// Stops event loop, reconnecting and closes socket
+func (s *Socket) Close() error {
+	s.deviceBase.Close()
+	return s.connection.Close()
+}
+
+func (s *Socket) functionResolver(sequenceEvent event.SequenceEvent, call Call) test.Result {
+	switch call.Function {
+	case "Connect":
+		if err := s.connection.Open(); err != nil {
+			return test.Result{Result: test.Error, Message: err.Error()}
+		}
+		return test.Result{Result: test.Done, Message: "Connected to " + s.address}
+	case "Send":
+		return s.send(call.Params)
+	case "Receive-Until", "Expect-Regex":
+		return s.receive(sequenceEvent.GetContext(), call.Params)
+	case "Send-Receive":
+		return sendReceive(func() test.Result { return s.send(call.Params) }, func() test.Result { return s.receive(sequenceEvent.GetContext(), call.Params) }, call.Params)
+	case "Close":
+		if err := s.connection.Disconnect(); err != nil {
+			return test.Result{Result: test.Error, Message: err.Error()}
+		}
+		return test.Result{Result: test.Done, Message: "Closed connection to " + s.address}
+	default:
+		return test.Result{Result: test.Error, Message: "Function not found: " + call.Function}
+	}
+}
+
+func (s *Socket) send(params Params) test.Result {
+	data, err := encodeRequest(params)
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	conn, err := s.connection.Get()
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	conn.SetWriteDeadline(time.Now().Add(s.connectTimeout))
+	if _, err := conn.Write(data); err != nil {
+		s.connection.Lost(conn, err)
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	return test.Result{Result: test.Done, Message: "Tx: " + formatPayload(data, binaryRequest(params))}
+}
+
+// Receives response until it is complete as described by parameters and checks it - same as genericuart
+// UDP response is read datagram by datagram until it is complete
+func (s *Socket) receive(stepContext context.Context, params Params) test.Result {
+	spec, err := newResponseSpec(params)
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	conn, err := s.connection.Get()
+	if err != nil {
+		return test.Result{Result: test.Error, Message: err.Error()}
+	}
+	response, err := readResponse(stepContext, func(buffer []byte, timeout time.Duration) (int, error) {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buffer)
+		if isTimeout(err) {
+			return n, nil
+		}
+		return n, err
+	}, s.readTimeout, spec)
+	if err != nil {
+		if stepContext.Err() == nil {
+			s.connection.Lost(conn, err)
+		}
+		return test.Result{Result: test.Error, Message: err.Error() + ", Rx: " + formatPayload(response, spec.binary)}
+	}
+	return spec.check(response)
+}
+
+func isTimeout(err error) bool {
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
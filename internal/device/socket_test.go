@@ -0,0 +1,146 @@
+package device
+
+import (
+	"bufio"
+	"checkerbox/internal/test"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TCP console answering lines - PING with prompt, VER with version line, DROP closes connection
+// Returns port it listens on and counter of accepted connections
+func startConsoleServer(t *testing.T) (int, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch strings.TrimSpace(line) {
+					case "PING":
+						conn.Write([]byte("PONG\r\n> "))
+					case "VER":
+						conn.Write([]byte("firmware version 1.2.3\r\n"))
+					case "DROP":
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, accepted
+}
+
+func TestSocketSendReceive(t *testing.T) {
+	port, _ := startConsoleServer(t)
+	socket := startDevice(t, "socket", map[string]any{"host": "127.0.0.1", "port": port})
+
+	result := runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "PING\n", "until": "> "})
+	if result.Result != test.Done || result.Data != "PONG\r\n> " {
+		t.Fatalf("Unexpected result of until: %v", result)
+	}
+
+	result = runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "VER\n", "regex": `version (\d+\.\d+\.\d+)`})
+	if result.Result != test.Done || len(result.Measurements) == 0 || result.Measurements[0].Text != "1.2.3" {
+		t.Fatalf("Unexpected result of regex: %v", result)
+	}
+
+	result = runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "VER\n", "regex": `version (\d+)$`, "timeout": 200})
+	if result.Result != test.Fail {
+		t.Fatalf("Response not matching regex has to fail, got %v", result)
+	}
+
+	result = runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "VER\n", "until": "> ", "timeout": 200})
+	if result.Result != test.Error || !strings.Contains(result.Message, "not received") {
+		t.Fatalf("Missing terminator has to be error, got %v", result)
+	}
+}
+
+func TestSocketReconnect(t *testing.T) {
+	port, accepted := startConsoleServer(t)
+	socket := startDevice(t, "socket", map[string]any{
+		"host":                   "127.0.0.1",
+		"port":                   port,
+		"reconnect_interval":     20,
+		"reconnect_max_interval": 100,
+	})
+	statusChannel := make(chan StatusEvent, 10)
+	socket.SetStatusChannel(statusChannel)
+
+	// Server drops connection - read fails and socket reconnects in background
+	result := runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "DROP\n", "until": "> "})
+	if result.Result != test.Error {
+		t.Fatalf("Dropped connection has to be error, got %v", result)
+	}
+	for _, available := range []bool{false, true} {
+		select {
+		case status := <-statusChannel:
+			if status.Available != available {
+				t.Fatalf("Unexpected status: %v", status)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Status change to available=%v not reported", available)
+		}
+	}
+	if err := CheckHealth(socket); err != nil {
+		t.Fatalf("Reconnected socket isn't healthy: %v", err)
+	}
+
+	result = runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "PING\n", "until": "> "})
+	if result.Result != test.Done {
+		t.Fatalf("Step after reconnect failed: %v", result)
+	}
+	if accepted.Load() != 2 {
+		t.Fatalf("Expected 2 connections, server accepted %v", accepted.Load())
+	}
+}
+
+func TestSocketUdp(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	// Every datagram is answered in two datagrams, response is read until it is complete
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, address, err := server.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			server.WriteTo([]byte("ECHO "), address)
+			server.WriteTo(append(buffer[:n:n], '\n'), address)
+		}
+	}()
+	socket := startDevice(t, "socket", map[string]any{"host": "127.0.0.1", "port": server.LocalAddr().(*net.UDPAddr).Port, "protocol": "udp"})
+
+	result := runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "hello", "until": "\n"})
+	if result.Result != test.Done || result.Data != "ECHO hello\n" {
+		t.Fatalf("Unexpected UDP response: %v", result)
+	}
+
+	result = runStep(t, socket, map[string]any{"function": "Send-Receive", "data": "hello", "threshold": "ECHO hello\n"})
+	if err := test.ApplyLimits(&result, nil); err != nil || result.Result != test.Pass {
+		t.Fatalf("Response matching threshold has to pass, got %v", result)
+	}
+}
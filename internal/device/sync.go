@@ -0,0 +1,205 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SyncManager holds synchronization primitives shared between sequence devices of all sites
+// Primitives are identified by name and created on first use - barriers are separate for every stage
+// Sites whose run ended aren't waited for on barriers until they start next run
+type SyncManager struct {
+	mutex      sync.Mutex
+	stageSites map[int][]int
+	barriers   map[barrierKey]*barrier
+	semaphores map[string]*semaphore
+	ended      map[int]bool
+}
+
+type barrierKey struct {
+	stage int
+	name  string
+}
+
+type barrier struct {
+	participants []int
+	arrived      []int
+	release      chan struct{}
+}
+
+// Semaphore with capacity 1 is used as lock - holders are tracked so site can release only what it acquired
+type semaphore struct {
+	slots   chan struct{}
+	holders map[int]int
+}
+
+func NewSyncManager(stageSites map[int][]int) *SyncManager {
+	return &SyncManager{
+		stageSites: stageSites,
+		barriers:   make(map[barrierKey]*barrier),
+		semaphores: make(map[string]*semaphore),
+		ended:      make(map[int]bool),
+	}
+}
+
+// Marks site as running so barriers wait for it again after its previous run ended
+func (m *SyncManager) StartRun(site int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.ended, site)
+}
+
+// Blocks until every participant site still running reaches barrier with the same name in the same stage or timeout expires
+// Empty participants list means all sites of the stage - participants are set by the first site reaching barrier
+// onWait is called when site has to wait for others
+func (m *SyncManager) Barrier(stepContext context.Context, name string, stage, site int, participants []int, timeout time.Duration, onWait func()) error {
+	m.mutex.Lock()
+	if len(participants) == 0 {
+		participants = m.stageSites[stage]
+	}
+	for _, participant := range participants {
+		if !slices.Contains(m.stageSites[stage], participant) {
+			m.mutex.Unlock()
+			return fmt.Errorf("Site %v of barrier %s doesn't belong to stage %v", participant, name, stage)
+		}
+	}
+	key := barrierKey{stage: stage, name: name}
+	currentBarrier, ok := m.barriers[key]
+	if !ok {
+		currentBarrier = &barrier{
+			participants: participants,
+			release:      make(chan struct{}),
+		}
+	}
+	if !slices.Contains(currentBarrier.participants, site) {
+		m.mutex.Unlock()
+		return fmt.Errorf("Site %v is not a participant of barrier %s", site, name)
+	}
+	m.barriers[key] = currentBarrier
+	delete(m.ended, site)
+	if !slices.Contains(currentBarrier.arrived, site) {
+		currentBarrier.arrived = append(currentBarrier.arrived, site)
+	}
+	// Last site to arrive releases everyone and resets barrier so it can be used again
+	if m.releaseIfComplete(key, currentBarrier) {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.mutex.Unlock()
+
+	onWait()
+	select {
+	case <-currentBarrier.release:
+		return nil
+	case <-time.After(timeout):
+		return m.leaveBarrier(currentBarrier, site, errors.New("Timeout waiting on barrier "+name))
+	case <-stepContext.Done():
+		return m.leaveBarrier(currentBarrier, site, errors.New("Cancelled while waiting on barrier "+name))
+	}
+}
+
+// Releases waiting sites and removes barrier when every participant except ended ones has arrived
+func (m *SyncManager) releaseIfComplete(key barrierKey, currentBarrier *barrier) bool {
+	for _, participant := range currentBarrier.participants {
+		if !m.ended[participant] && !slices.Contains(currentBarrier.arrived, participant) {
+			return false
+		}
+	}
+	close(currentBarrier.release)
+	delete(m.barriers, key)
+	return true
+}
+
+// Removes site from barrier it stopped waiting on, unless barrier was released in the meantime
+func (m *SyncManager) leaveBarrier(currentBarrier *barrier, site int, err error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	select {
+	case <-currentBarrier.release:
+		return nil
+	default:
+	}
+	currentBarrier.arrived = slices.DeleteFunc(currentBarrier.arrived, func(arrivedSite int) bool { return arrivedSite == site })
+	return err
+}
+
+// Takes one slot of named semaphore, blocking until slot is free or timeout expires
+// Capacity is set by the first site using semaphore with given name. onWait is called when site has to wait for free slot
+func (m *SyncManager) Acquire(stepContext context.Context, name string, site, capacity int, timeout time.Duration, onWait func()) error {
+	if capacity < 1 {
+		return fmt.Errorf("Invalid capacity of semaphore %s: %v", name, capacity)
+	}
+	m.mutex.Lock()
+	currentSemaphore, ok := m.semaphores[name]
+	if !ok {
+		currentSemaphore = &semaphore{
+			slots:   make(chan struct{}, capacity),
+			holders: make(map[int]int),
+		}
+		m.semaphores[name] = currentSemaphore
+	} else if cap(currentSemaphore.slots) != capacity {
+		m.mutex.Unlock()
+		return fmt.Errorf("%s already used with capacity %v", name, cap(currentSemaphore.slots))
+	}
+	m.mutex.Unlock()
+
+	select {
+	case currentSemaphore.slots <- struct{}{}:
+		m.addHolder(currentSemaphore, site)
+		return nil
+	default:
+	}
+	onWait()
+	select {
+	case currentSemaphore.slots <- struct{}{}:
+		m.addHolder(currentSemaphore, site)
+		return nil
+	case <-time.After(timeout):
+		return errors.New("Timeout waiting on " + name)
+	case <-stepContext.Done():
+		return errors.New("Cancelled while waiting on " + name)
+	}
+}
+
+// Frees one slot of named semaphore held by site
+func (m *SyncManager) Release(name string, site int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	currentSemaphore, ok := m.semaphores[name]
+	if !ok || currentSemaphore.holders[site] == 0 {
+		return fmt.Errorf("Site %v doesn't hold %s", site, name)
+	}
+	currentSemaphore.holders[site]--
+	<-currentSemaphore.slots
+	return nil
+}
+
+func (m *SyncManager) addHolder(currentSemaphore *semaphore, site int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	currentSemaphore.holders[site]++
+}
+
+// Frees every semaphore and lock held by site and stops barriers from waiting for it until it starts next run
+// Called when sequence of the site ends so failed sites don't block the others
+func (m *SyncManager) ReleaseAll(site int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, currentSemaphore := range m.semaphores {
+		for ; currentSemaphore.holders[site] > 0; currentSemaphore.holders[site]-- {
+			<-currentSemaphore.slots
+		}
+	}
+	m.ended[site] = true
+	for key, currentBarrier := range m.barriers {
+		currentBarrier.arrived = slices.DeleteFunc(currentBarrier.arrived, func(arrivedSite int) bool { return arrivedSite == site })
+		// Sites already waiting don't have to wait out timeout for site that won't come
+		if len(currentBarrier.arrived) > 0 {
+			m.releaseIfComplete(key, currentBarrier)
+		}
+	}
+}
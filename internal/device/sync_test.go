@@ -0,0 +1,95 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Runs barrier of site in background and returns channel receiving its result
+func barrierAsync(manager *SyncManager, name string, site int, timeout time.Duration) chan error {
+	results := make(chan error, 1)
+	go func() {
+		results <- manager.Barrier(context.Background(), name, 0, site, nil, timeout, func() {})
+	}()
+	return results
+}
+
+// Waits until given number of sites is registered as arrived on barrier
+func waitArrived(t *testing.T, manager *SyncManager, name string, count int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		manager.mutex.Lock()
+		currentBarrier, ok := manager.barriers[barrierKey{stage: 0, name: name}]
+		arrived := 0
+		if ok {
+			arrived = len(currentBarrier.arrived)
+		}
+		manager.mutex.Unlock()
+		if arrived >= count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v sites didn't arrive on barrier %s", count, name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func expectReleased(t *testing.T, results chan error) {
+	t.Helper()
+	select {
+	case err := <-results:
+		if err != nil {
+			t.Fatalf("Barrier failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Barrier wasn't released")
+	}
+}
+
+func TestBarrierReleasesWhenAllSitesArrive(t *testing.T) {
+	manager := NewSyncManager(map[int][]int{0: {0, 1, 2}})
+	first := barrierAsync(manager, "ready", 0, time.Minute)
+	second := barrierAsync(manager, "ready", 1, time.Minute)
+	waitArrived(t, manager, "ready", 2)
+	select {
+	case err := <-first:
+		t.Fatalf("Barrier released before last site arrived: %v", err)
+	default:
+	}
+	if err := manager.Barrier(context.Background(), "ready", 0, 2, nil, time.Minute, func() {}); err != nil {
+		t.Fatalf("Barrier failed: %v", err)
+	}
+	expectReleased(t, first)
+	expectReleased(t, second)
+}
+
+func TestBarrierDoesNotWaitForEndedSite(t *testing.T) {
+	t.Run("site ends while others wait", func(t *testing.T) {
+		manager := NewSyncManager(map[int][]int{0: {0, 1, 2}})
+		first := barrierAsync(manager, "ready", 0, time.Minute)
+		second := barrierAsync(manager, "ready", 1, time.Minute)
+		waitArrived(t, manager, "ready", 2)
+		manager.ReleaseAll(2)
+		expectReleased(t, first)
+		expectReleased(t, second)
+	})
+
+	t.Run("site ended before others arrive", func(t *testing.T) {
+		manager := NewSyncManager(map[int][]int{0: {0, 1}})
+		manager.ReleaseAll(1)
+		expectReleased(t, barrierAsync(manager, "ready", 0, time.Minute))
+	})
+
+	t.Run("site is waited for again in next run", func(t *testing.T) {
+		manager := NewSyncManager(map[int][]int{0: {0, 1}})
+		manager.ReleaseAll(1)
+		manager.StartRun(1)
+		err := manager.Barrier(context.Background(), "ready", 0, 0, nil, 50*time.Millisecond, func() {})
+		if err == nil || err.Error() != "Timeout waiting on barrier ready" {
+			t.Fatalf("Expected timeout waiting on running site, got %v", err)
+		}
+	})
+}
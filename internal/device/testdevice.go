@@ -7,55 +7,43 @@ import (
 )
 
 type TestDevice struct {
-	eventChannel chan event.Event
-	site         int
+	deviceBase
 }
 
-func NewTestDevice(site int) (*TestDevice, error) {
-	return &TestDevice{
-		site:         int(site),
-		eventChannel: make(chan event.Event, 100),
-	}, nil
+// Test device has no settings
+type TestDeviceSettings struct{}
+
+func init() {
+	RegisterDriver("testdevice", TestDeviceSettings{}, func(instanceName string, site int, settings TestDeviceSettings) (Device, error) {
+		return NewTestDevice(instanceName, site)
+	},
+		Function{Name: "TestAction1", Description: "Returns done"},
+		Function{Name: "TestAction2", Description: "Returns done"},
+		Function{Name: "TestAction3", Description: "Returns done"},
+		Function{Name: "TestMeasure", Description: "Returns given value as measurement", Params: []Param{
+			{Name: "value", Type: ParamAny, Required: true, Description: "value to measure"},
+		}},
+	)
 }
 
-func (t *TestDevice) SequenceEventHandler() {
-	for receivedEvent := range t.eventChannel {
-		sequenceEvent, ok := receivedEvent.Data.(event.SequenceEvent)
-		if !ok || sequenceEvent.DeviceName != "testdevice" || sequenceEvent.Site != t.site {
-			continue
-		}
-
-		siteResultChannel := receivedEvent.ReturnChannel
-		result := t.functionResolver(sequenceEvent)
-		result.Site = sequenceEvent.Site
-		result.Id = sequenceEvent.Id
-		result.Label = sequenceEvent.Label
-		siteResultChannel <- result
-	}
-}
-
-func (t *TestDevice) GetEventChannel() chan event.Event {
-	return t.eventChannel
+func NewTestDevice(instanceName string, site int) (*TestDevice, error) {
+	testDevice := &TestDevice{}
+	testDevice.deviceBase = newDeviceBase("testdevice", instanceName, site, testDevice.functionResolver)
+	return testDevice, nil
 }
 
-func (t *TestDevice) functionResolver(sequenceEvent event.SequenceEvent) test.Result {
-	function, ok := sequenceEvent.StepSettings["function"].(string)
-	if !ok {
-		return test.Result{Result: test.Error, Message: "Error parsing function name"}
-	}
-
-	switch function {
+func (t *TestDevice) functionResolver(sequenceEvent event.SequenceEvent, call Call) test.Result {
+	switch call.Function {
 	case "TestAction1":
 		return test.Result{Result: test.Done, Message: "TestAction1"}
 	case "TestAction2":
 		return test.Result{Result: test.Done, Message: "TestAction2"}
 	case "TestAction3":
 		return test.Result{Result: test.Done, Message: "TestAction3"}
+	case "TestMeasure":
+		measurement := test.NewMeasurement("", fmt.Sprintf("%v", call.Params.Value("value")))
+		return test.Result{Result: test.Done, Message: "TestMeasure", Measurements: []test.Measurement{measurement}}
 	default:
-		return test.Result{Result: test.Error, Message: "Function not found: "}
+		return test.Result{Result: test.Error, Message: "Function not found: " + call.Function}
 	}
 }
-
-func (t *TestDevice) Print() {
-	fmt.Println("Test device at site: " + fmt.Sprintf("%v", t.site))
-}
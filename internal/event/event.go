@@ -3,6 +3,7 @@ package event
 import (
 	"checkerbox/internal/data"
 	"checkerbox/internal/test"
+	"context"
 	"slices"
 	"sync"
 )
@@ -18,9 +19,23 @@ type SequenceEvent struct {
 	Label        string
 	DeviceName   string
 	Retry        int
+	Stage        int
 	Site         int
 	Timeout      int
 	StepSettings map[string]any
+	// Sequence number of dispatch, unique for every try of every step pass in site run - results carry it back,
+	// so late result of step executed again by retry, repeat or jump isn't taken for result of the current try
+	Dispatch uint
+	// Context cancelled when step times out or sequence is aborted - devices should stop in-flight work when it's done
+	Context context.Context
+}
+
+// Returns context of the step or background context if event was sent without one
+func (s SequenceEvent) GetContext() context.Context {
+	if s.Context == nil {
+		return context.Background()
+	}
+	return s.Context
 }
 
 type GraphicEvent struct {
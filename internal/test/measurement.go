@@ -0,0 +1,200 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ComparisonType int
+
+// Comparison modes - single limit comparisons EQ, NE, GT and GE use low limit, LT and LE use high limit
+// Range comparisons use both limits, LOG only records value without evaluating it
+const (
+	LOG ComparisonType = iota
+	EQ
+	NE
+	GT
+	GE
+	LT
+	LE
+	GELE
+	GTLT
+	GELT
+	GTLE
+)
+
+func (ct ComparisonType) String() string {
+	return [...]string{"LOG", "EQ", "NE", "GT", "GE", "LT", "LE", "GELE", "GTLT", "GELT", "GTLE"}[ct]
+}
+
+// Returns comparison type with given name
+func ParseComparisonType(name string) (ComparisonType, bool) {
+	for ct := LOG; ct <= GTLE; ct++ {
+		if ct.String() == strings.ToUpper(name) {
+			return ct, true
+		}
+	}
+	return LOG, false
+}
+
+// Measurement holds value returned by device together with limits it is checked against
+// Text is raw value as returned by device - when Expected is set, EQ and NE compare text instead of numeric value
+type Measurement struct {
+	Name       string
+	Value      float64
+	Numeric    bool
+	Text       string
+	Unit       string
+	Low        float64
+	High       float64
+	Expected   string
+	Comparison ComparisonType
+	Result     ResultType
+}
+
+// Creates measurement from text returned by device - value is numeric if whole text parses as a number
+func NewMeasurement(name, text string) Measurement {
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	return Measurement{
+		Name:    name,
+		Value:   value,
+		Numeric: err == nil,
+		Text:    text,
+		Result:  Done,
+	}
+}
+
+// Checks measurement against its limits, sets and returns result - Pass or Fail, Done for LOG comparison
+func (m *Measurement) Evaluate() ResultType {
+	if m.Comparison == LOG {
+		m.Result = Done
+		return m.Result
+	}
+	if m.Expected != "" && (m.Comparison == EQ || m.Comparison == NE) {
+		m.Result = resultOf((m.Text == m.Expected) == (m.Comparison == EQ))
+		return m.Result
+	}
+	if !m.Numeric {
+		m.Result = Fail
+		return m.Result
+	}
+
+	var passed bool
+	switch m.Comparison {
+	case EQ:
+		passed = m.Value == m.Low
+	case NE:
+		passed = m.Value != m.Low
+	case GT:
+		passed = m.Value > m.Low
+	case GE:
+		passed = m.Value >= m.Low
+	case LT:
+		passed = m.Value < m.High
+	case LE:
+		passed = m.Value <= m.High
+	case GELE:
+		passed = m.Value >= m.Low && m.Value <= m.High
+	case GTLT:
+		passed = m.Value > m.Low && m.Value < m.High
+	case GELT:
+		passed = m.Value >= m.Low && m.Value < m.High
+	case GTLE:
+		passed = m.Value > m.Low && m.Value <= m.High
+	}
+	m.Result = resultOf(passed)
+	return m.Result
+}
+
+func resultOf(passed bool) ResultType {
+	if passed {
+		return Pass
+	}
+	return Fail
+}
+
+// Returns measurement in readable form, i.e: "vout 3.31V GELE[3.2, 3.4] Pass"
+func (m Measurement) String() string {
+	value := m.Text
+	if m.Numeric {
+		value = strconv.FormatFloat(m.Value, 'g', -1, 64)
+	}
+	measurementString := strings.TrimSpace(m.Name + " " + value + m.Unit)
+	switch m.Comparison {
+	case LOG:
+		return measurementString
+	case EQ, NE, GT, GE:
+		// Expected text is compared only by EQ and NE, the same way measurement is evaluated
+		if m.Expected != "" && (m.Comparison == EQ || m.Comparison == NE) {
+			return measurementString + " " + m.Comparison.String() + "[" + m.Expected + "] " + m.Result.String()
+		}
+		return measurementString + " " + m.Comparison.String() + "[" + fmt.Sprintf("%v", m.Low) + "] " + m.Result.String()
+	case LT, LE:
+		return measurementString + " " + m.Comparison.String() + "[" + fmt.Sprintf("%v", m.High) + "] " + m.Result.String()
+	default:
+		return measurementString + " " + m.Comparison.String() + "[" + fmt.Sprintf("%v, %v", m.Low, m.High) + "] " + m.Result.String()
+	}
+}
+
+// Applies limits from step config to measurements returned by device and resolves step result from all measurements
+// Limit is matched with measurement by name, or by position when limit or measurement has no name. When device returned no measurements,
+// result message is parsed as value of the first measurement. Results other than Pass and Done are left untouched
+// Limit matched with measurement that is already checked - by device (i.e. threshold) or by another limit - is rejected
+func ApplyLimits(result *Result, limits []Measurement) error {
+	if result.Result != Pass && result.Result != Done {
+		return nil
+	}
+	if len(limits) > 0 && len(result.Measurements) == 0 {
+		result.Measurements = append(result.Measurements, NewMeasurement("", result.Message))
+	}
+	for i, limit := range limits {
+		index := -1
+		for n, measurement := range result.Measurements {
+			if limit.Name != "" && measurement.Name == limit.Name {
+				index = n
+				break
+			}
+		}
+		if index < 0 && i < len(result.Measurements) && (limit.Name == "" || result.Measurements[i].Name == "") {
+			index = i
+		}
+		if index < 0 {
+			return errors.New("No measurement returned for limit " + fmt.Sprintf("%v %s", i, limit.Name))
+		}
+		measurement := &result.Measurements[index]
+		if measurement.Comparison != LOG {
+			return errors.New("Limit " + strings.TrimSpace(fmt.Sprintf("%v %s", i, limit.Name)) + " conflicts with " + measurement.Comparison.String() + " check of the same measurement")
+		}
+		if measurement.Name == "" {
+			measurement.Name = limit.Name
+		}
+		if limit.Unit != "" {
+			measurement.Unit = limit.Unit
+		}
+		measurement.Low = limit.Low
+		measurement.High = limit.High
+		measurement.Expected = limit.Expected
+		measurement.Comparison = limit.Comparison
+	}
+
+	evaluated := false
+	failed := false
+	for i := range result.Measurements {
+		if result.Measurements[i].Comparison == LOG {
+			result.Measurements[i].Evaluate()
+			continue
+		}
+		evaluated = true
+		if result.Measurements[i].Evaluate() == Fail {
+			failed = true
+		}
+	}
+	if failed {
+		result.Result = Fail
+	} else if evaluated {
+		result.Result = Pass
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name        string
+		measurement Measurement
+		expected    ResultType
+	}{
+		{"LOG", Measurement{Value: 1, Numeric: true, Comparison: LOG}, Done},
+		{"EQ equal", Measurement{Value: 5, Numeric: true, Low: 5, Comparison: EQ}, Pass},
+		{"EQ different", Measurement{Value: 5.1, Numeric: true, Low: 5, Comparison: EQ}, Fail},
+		{"NE different", Measurement{Value: 4, Numeric: true, Low: 5, Comparison: NE}, Pass},
+		{"NE equal", Measurement{Value: 5, Numeric: true, Low: 5, Comparison: NE}, Fail},
+		{"EQ expected text", Measurement{Text: "OK", Expected: "OK", Comparison: EQ}, Pass},
+		{"EQ other text", Measurement{Text: "ERR", Expected: "OK", Comparison: EQ}, Fail},
+		{"NE expected text", Measurement{Text: "OK", Expected: "OK", Comparison: NE}, Fail},
+		{"NE other text", Measurement{Text: "ERR", Expected: "OK", Comparison: NE}, Pass},
+		{"GT above", Measurement{Value: 5.1, Numeric: true, Low: 5, Comparison: GT}, Pass},
+		{"GT at limit", Measurement{Value: 5, Numeric: true, Low: 5, Comparison: GT}, Fail},
+		{"GE at limit", Measurement{Value: 5, Numeric: true, Low: 5, Comparison: GE}, Pass},
+		{"GE below", Measurement{Value: 4.9, Numeric: true, Low: 5, Comparison: GE}, Fail},
+		{"GE ignores expected", Measurement{Text: "7", Value: 7, Numeric: true, Low: 5, Expected: "9", Comparison: GE}, Pass},
+		{"LT below", Measurement{Value: 4.9, Numeric: true, High: 5, Comparison: LT}, Pass},
+		{"LT at limit", Measurement{Value: 5, Numeric: true, High: 5, Comparison: LT}, Fail},
+		{"LE at limit", Measurement{Value: 5, Numeric: true, High: 5, Comparison: LE}, Pass},
+		{"LE above", Measurement{Value: 5.1, Numeric: true, High: 5, Comparison: LE}, Fail},
+		{"GELE low limit", Measurement{Value: 1, Numeric: true, Low: 1, High: 2, Comparison: GELE}, Pass},
+		{"GELE high limit", Measurement{Value: 2, Numeric: true, Low: 1, High: 2, Comparison: GELE}, Pass},
+		{"GELE outside", Measurement{Value: 2.1, Numeric: true, Low: 1, High: 2, Comparison: GELE}, Fail},
+		{"GTLT low limit", Measurement{Value: 1, Numeric: true, Low: 1, High: 2, Comparison: GTLT}, Fail},
+		{"GTLT high limit", Measurement{Value: 2, Numeric: true, Low: 1, High: 2, Comparison: GTLT}, Fail},
+		{"GTLT inside", Measurement{Value: 1.5, Numeric: true, Low: 1, High: 2, Comparison: GTLT}, Pass},
+		{"GELT low limit", Measurement{Value: 1, Numeric: true, Low: 1, High: 2, Comparison: GELT}, Pass},
+		{"GELT high limit", Measurement{Value: 2, Numeric: true, Low: 1, High: 2, Comparison: GELT}, Fail},
+		{"GTLE low limit", Measurement{Value: 1, Numeric: true, Low: 1, High: 2, Comparison: GTLE}, Fail},
+		{"GTLE high limit", Measurement{Value: 2, Numeric: true, Low: 1, High: 2, Comparison: GTLE}, Pass},
+		{"not numeric", Measurement{Text: "abc", Low: 1, High: 2, Comparison: GELE}, Fail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.measurement.Evaluate(); result != tt.expected || tt.measurement.Result != tt.expected {
+				t.Errorf("Evaluated to %s, expected %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMeasurementString(t *testing.T) {
+	tests := []struct {
+		measurement Measurement
+		expected    string
+	}{
+		{Measurement{Name: "vout", Text: "3.31", Value: 3.31, Numeric: true, Unit: "V", Comparison: LOG}, "vout 3.31V"},
+		{Measurement{Name: "vout", Value: 3.31, Numeric: true, Unit: "V", Low: 3.2, High: 3.4, Comparison: GELE, Result: Pass}, "vout 3.31V GELE[3.2, 3.4] Pass"},
+		{Measurement{Value: 5, Numeric: true, Low: 5, Comparison: EQ, Result: Pass}, "5 EQ[5] Pass"},
+		{Measurement{Text: "OK", Expected: "OK", Comparison: NE, Result: Fail}, "OK NE[OK] Fail"},
+		{Measurement{Value: 4, Numeric: true, High: 5, Comparison: LT, Result: Pass}, "4 LT[5] Pass"},
+		// Expected text isn't compared by GT and GE, so numeric limit is shown
+		{Measurement{Text: "7", Value: 7, Numeric: true, Low: 5, Expected: "9", Comparison: GE, Result: Pass}, "7 GE[5] Pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if result := tt.measurement.String(); result != tt.expected {
+				t.Errorf("Got %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		result       Result
+		limits       []Measurement
+		expected     ResultType
+		measurements string
+		err          string
+	}{
+		{
+			name:         "message parsed as value",
+			result:       Result{Result: Done, Message: "4.2"},
+			limits:       []Measurement{{Name: "vout", Unit: "V", Low: 4, High: 5, Comparison: GELE}},
+			expected:     Pass,
+			measurements: "vout 4.2V GELE[4, 5] Pass",
+		},
+		{
+			name:         "matched by name",
+			result:       Result{Result: Done, Measurements: []Measurement{NewMeasurement("a", "1"), NewMeasurement("b", "9")}},
+			limits:       []Measurement{{Name: "b", Low: 5, Comparison: GE}},
+			expected:     Pass,
+			measurements: "a 1, b 9 GE[5] Pass",
+		},
+		{
+			name:         "matched by position",
+			result:       Result{Result: Done, Measurements: []Measurement{NewMeasurement("", "1"), NewMeasurement("", "9")}},
+			limits:       []Measurement{{Comparison: LOG}, {High: 5, Comparison: LT}},
+			expected:     Fail,
+			measurements: "1, 9 LT[5] Fail",
+		},
+		{
+			name:     "fail isn't changed",
+			result:   Result{Result: Fail, Message: "9"},
+			limits:   []Measurement{{Low: 5, Comparison: GE}},
+			expected: Fail,
+		},
+		{
+			name:   "missing measurement",
+			result: Result{Result: Done, Measurements: []Measurement{NewMeasurement("a", "1")}},
+			limits: []Measurement{{Name: "b", Low: 5, Comparison: GE}},
+			err:    "No measurement returned for limit 0 b",
+		},
+		{
+			name:   "limit conflicts with device check",
+			result: Result{Result: Done, Measurements: []Measurement{{Text: "OK", Expected: "OK", Comparison: EQ, Result: Done}}},
+			limits: []Measurement{{Low: 5, Comparison: GE}},
+			err:    "Limit 0 conflicts with EQ check of the same measurement",
+		},
+		{
+			name:   "two limits of one measurement",
+			result: Result{Result: Done, Measurements: []Measurement{NewMeasurement("a", "1")}},
+			limits: []Measurement{{Name: "a", Low: 0, Comparison: GE}, {Name: "a", High: 5, Comparison: LE}},
+			err:    "Limit 1 a conflicts with GE check of the same measurement",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyLimits(&tt.result, tt.limits)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Applying limits failed: %v", err)
+			}
+			if tt.result.Result != tt.expected {
+				t.Errorf("Result %s, expected %s", tt.result.Result, tt.expected)
+			}
+			if measurements := tt.result.MeasurementString(); measurements != tt.measurements && tt.measurements != "" {
+				t.Errorf("Measurements %q, expected %q", measurements, tt.measurements)
+			}
+		})
+	}
+}
+
+func TestParseComparisonType(t *testing.T) {
+	for ct := LOG; ct <= GTLE; ct++ {
+		if parsed, ok := ParseComparisonType(strings.ToLower(ct.String())); !ok || parsed != ct {
+			t.Errorf("%s parsed as %s, %v", ct, parsed, ok)
+		}
+	}
+	if _, ok := ParseComparisonType("ABOUT"); ok {
+		t.Errorf("Unknown comparison parsed")
+	}
+}
@@ -1,5 +1,7 @@
 package test
 
+import "strings"
+
 type ResultType int
 
 const (
@@ -8,28 +10,56 @@ const (
 	Done
 	Error
 	InProgress
+	Waiting
+	Aborted
+	Skipped
 )
 
 func (rt ResultType) String() string {
-	return [...]string{"Fail", "Pass", "Done", "Error", "InProgress"}[rt]
+	return [...]string{"Fail", "Pass", "Done", "Error", "InProgress", "Waiting", "Aborted", "Skipped"}[rt]
+}
+
+// Returns result type with given name
+func ParseResultType(name string) (ResultType, bool) {
+	for rt := Fail; rt <= Skipped; rt++ {
+		if rt.String() == name {
+			return rt, true
+		}
+	}
+	return Fail, false
 }
 
 type Result struct {
+	Stage   int
 	Site    int
 	Id      uint
 	Label   string
 	Result  ResultType
 	Message string
-	Retried int
+	// Data returned by device, without description added to message - captured into variables
+	Data         string
+	Retried      int
+	Measurements []Measurement
+	// Sequence number of dispatch result answers, copied from sequence event
+	Dispatch uint
+}
+
+// Returns measurements in readable form joined into one line
+func (r Result) MeasurementString() string {
+	measurementStrings := make([]string, 0, len(r.Measurements))
+	for _, measurement := range r.Measurements {
+		measurementStrings = append(measurementStrings, measurement.String())
+	}
+	return strings.Join(measurementStrings, ", ")
 }
 
 func NewResult(result ResultType, retried, site int, id uint, label, message string) Result {
 	return Result{
-		site,
-		id,
-		label,
-		result,
-		message,
-		retried,
+		Site:    site,
+		Id:      id,
+		Label:   label,
+		Result:  result,
+		Message: message,
+		Retried: retried,
 	}
 }
@@ -7,4 +7,6 @@ import (
 type GraphicInterface interface {
 	GraphicEventHandler()
 	GetEventChannel() chan event.Event
+	// Stops interface and returns once terminal is restored - events sent afterwards are dropped
+	Stop()
 }
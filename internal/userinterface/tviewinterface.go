@@ -5,6 +5,9 @@ import (
 	"checkerbox/internal/test"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
@@ -15,22 +18,35 @@ type TviewInterface struct {
 	eventChannel    chan event.Event
 	returnChannel   chan event.ControlEvent
 	sites           int
+	configDir       string
 	sitesFinished   int
 	sequenceRunning bool
 	noError         bool
+	stopOnce        sync.Once
+	stop            chan struct{}
+	stopped         chan struct{}
 }
 
-func NewTviewInterace(sites int, returnChannel chan event.ControlEvent) *TviewInterface {
+func NewTviewInterace(sites int, configDir string, returnChannel chan event.ControlEvent) *TviewInterface {
 	return &TviewInterface{
 		eventChannel:    make(chan event.Event),
 		returnChannel:   returnChannel,
 		sites:           sites,
+		configDir:       configDir,
 		sitesFinished:   0,
 		sequenceRunning: false,
 		noError:         false,
+		stop:            make(chan struct{}),
+		stopped:         make(chan struct{}),
 	}
 }
 
+// Stops tview application - event loop keeps draining events so publishers aren't blocked by stopped application
+func (t *TviewInterface) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.stopped
+}
+
 func (t *TviewInterface) GetEventChannel() chan event.Event {
 	return t.eventChannel
 }
@@ -38,6 +54,9 @@ func (t *TviewInterface) GetEventChannel() chan event.Event {
 func (t *TviewInterface) GraphicEventHandler() {
 	// Map used for displaying results sent by main routine
 	resultLists := make(map[int][]test.Result)
+	// Stage of every site and devices marked unavailable by failed health check - both shown in title of site box
+	siteStages := make(map[int]int)
+	unavailableDevices := make(map[int][]string)
 
 	// Instatiate tview app struct and pages struct which is main container for all widgets
 	app := tview.NewApplication()
@@ -68,13 +87,14 @@ func (t *TviewInterface) GraphicEventHandler() {
 
 	// Create layout for navigation section at the bottom of the screen
 	navBar := tview.NewFlex()
+	navigationText := "F1 [darkcyan]Sequence [white] F2 [darkcyan]DebugInfo [white] F3 [darkcyan]ConfigPicker [white] F5 [darkcyan]Reload [white]"
 	info := tview.NewTextView().
-		SetText("F1 [darkcyan]Sequence [white] F2 [darkcyan]DebugInfo [white] F3 [darkcyan]ConfigPicker [white]").
+		SetText(navigationText).
 		SetRegions(true).
 		SetDynamicColors(true).
 		SetTextAlign(tview.AlignLeft)
 	info2 := tview.NewTextView().
-		SetText("F10 [darkcyan]noError [white] F12 [darkcyan]SeqStart [white] CTRL+Q [darkcyan]Exit [white]").
+		SetText("F10 [darkcyan]noError [white] F11 [darkcyan]Abort [white] Alt+N [darkcyan]AbortSite [white] F12 [darkcyan]SeqStart [white] CTRL+Q [darkcyan]Exit [white]").
 		SetRegions(true).
 		SetDynamicColors(true).
 		SetTextAlign(tview.AlignRight)
@@ -93,19 +113,28 @@ func (t *TviewInterface) GraphicEventHandler() {
 	// Create page for choosing config file
 	configBox := tview.NewFlex()
 	configList := tview.NewList()
-	configFiles, err := os.ReadDir("./config/")
-	if err != nil {
-		fmt.Fprintf(debugTextField, "%s \n", err.Error())
-	}
-	for i, file := range configFiles {
-		configList.AddItem(file.Name(), "", rune(i+1), func() {
-			t.returnChannel <- event.ControlEvent{
-				Type: "CONFIGPICK",
-				Data: file.Name(),
+	// Config directory is listed every time picker is opened, so files added meanwhile are shown
+	// Picking file switches configuration in the same UI session
+	listConfigFiles := func() {
+		configList.Clear()
+		configFiles, err := os.ReadDir(t.configDir)
+		if err != nil {
+			fmt.Fprintf(debugTextField, "%s \n", err.Error())
+		}
+		for i, file := range configFiles {
+			if file.IsDir() {
+				continue
 			}
-			app.Stop()
-		})
+			configList.AddItem(file.Name(), "", rune(i+1), func() {
+				pages.SwitchToPage("Sequence")
+				t.returnChannel <- event.ControlEvent{
+					Type: "CONFIGPICK",
+					Data: file.Name(),
+				}
+			})
+		}
 	}
+	listConfigFiles()
 	modalFlex := tview.NewFlex().
 		SetDirection(tview.FlexRow).
 		AddItem(tview.NewTextView().SetText("Choose config file").SetTextAlign(tview.AlignCenter), 2, 1, false).
@@ -135,9 +164,9 @@ func (t *TviewInterface) GraphicEventHandler() {
 		} else if tcellEvent.Key() == tcell.KeyF10 {
 			t.noError = !t.noError
 			if t.noError {
-				info2.SetText("F10 [red]noError [white] F12 [darkcyan]SeqStart [white] CTRL+Q [darkcyan]Exit [white]")
+				info2.SetText("F10 [red]noError [white] F11 [darkcyan]Abort [white] Alt+N [darkcyan]AbortSite [white] F12 [darkcyan]SeqStart [white] CTRL+Q [darkcyan]Exit [white]")
 			} else {
-				info2.SetText("F10 [darkcyan]noError [white] F12 [darkcyan]SeqStart [white] CTRL+Q [darkcyan]Exit [white]")
+				info2.SetText("F10 [darkcyan]noError [white] F11 [darkcyan]Abort [white] Alt+N [darkcyan]AbortSite [white] F12 [darkcyan]SeqStart [white] CTRL+Q [darkcyan]Exit [white]")
 			}
 			t.returnChannel <- event.ControlEvent{
 				Type: "NOERROR",
@@ -161,10 +190,30 @@ func (t *TviewInterface) GraphicEventHandler() {
 					Type: "START",
 				}
 			}
+		} else if tcellEvent.Key() == tcell.KeyF11 {
+			if t.sequenceRunning {
+				t.returnChannel <- event.ControlEvent{
+					Type: "ABORT",
+				}
+			}
+		} else if tcellEvent.Key() == tcell.KeyRune && tcellEvent.Modifiers()&tcell.ModAlt != 0 && tcellEvent.Rune() >= '0' && tcellEvent.Rune() <= '9' {
+			// Alt + site number aborts sequence only on chosen site
+			site := int(tcellEvent.Rune() - '0')
+			if t.sequenceRunning && site < t.sites {
+				t.returnChannel <- event.ControlEvent{
+					Type: "ABORT",
+					Data: site,
+				}
+			}
 		} else if tcellEvent.Key() == tcell.KeyF3 {
+			listConfigFiles()
 			pages.SwitchToPage("ConfigPicker")
+		} else if tcellEvent.Key() == tcell.KeyF5 {
+			t.returnChannel <- event.ControlEvent{
+				Type: "CONFIGRELOAD",
+			}
 		} else if tcellEvent.Key() == tcell.KeyCtrlQ {
-			app.Stop()
+			// Application is stopped by main routine once running sequences ended
 			t.returnChannel <- event.ControlEvent{
 				Type: "QUIT",
 			}
@@ -188,6 +237,12 @@ func (t *TviewInterface) GraphicEventHandler() {
 			if !ok {
 				continue
 			}
+			// Updates queued to stopped application are never executed and would block forever
+			select {
+			case <-t.stopped:
+				continue
+			default:
+			}
 
 			// Switch on type of graphic event and modify related fields
 			switch graphicEvent.Type {
@@ -199,6 +254,22 @@ func (t *TviewInterface) GraphicEventHandler() {
 						fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "[red]%s [white]%s\n", graphicEvent.Result.Result, graphicEvent.Result.Label)
 					}
 				})
+			// Event assigning site to a stage. Changes title of the site box so stage of every site is visible
+			case "siteStage":
+				app.QueueUpdateDraw(func() {
+					siteStages[graphicEvent.Result.Site] = graphicEvent.Result.Stage
+					siteBoxes[graphicEvent.Result.Site].SetTitle(siteTitle(graphicEvent.Result.Site, siteStages, unavailableDevices))
+				})
+			// Event with result of device health check. Device failing it is listed in title of its site box until it passes again
+			case "deviceHealth":
+				app.QueueUpdateDraw(func() {
+					site := graphicEvent.Result.Site
+					unavailableDevices[site] = slices.DeleteFunc(unavailableDevices[site], func(name string) bool { return name == graphicEvent.Result.Label })
+					if graphicEvent.Result.Result != test.Pass {
+						unavailableDevices[site] = append(unavailableDevices[site], graphicEvent.Result.Label)
+					}
+					siteBoxes[site].SetTitle(siteTitle(site, siteStages, unavailableDevices))
+				})
 			// Event on start of the test. Sets new line in textview in referenced site unless there is already test referenced with the same ID
 			case "testStarted":
 				app.QueueUpdateDraw(func() {
@@ -209,27 +280,29 @@ func (t *TviewInterface) GraphicEventHandler() {
 							resultLists[graphicEvent.Result.Site] = append(resultLists[graphicEvent.Result.Site], graphicEvent.Result)
 							siteBoxes[graphicEvent.Result.Site].Clear()
 							for _, result := range resultLists[graphicEvent.Result.Site] {
-								fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v \n", result.Id, result.Result, result.Label, result.Message)
+								fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v \n", result.Id, result.Result, result.Label, resultMessage(result))
 							}
 						}
 					} else {
 						resultLists[graphicEvent.Result.Site] = append(resultLists[graphicEvent.Result.Site], graphicEvent.Result)
 						siteBoxes[graphicEvent.Result.Site].Clear()
 						for _, result := range resultLists[graphicEvent.Result.Site] {
-							fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v \n", result.Id, result.Result, result.Label, result.Message)
+							fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v \n", result.Id, result.Result, result.Label, resultMessage(result))
 						}
 					}
 				})
-				// Event indicating end of a test. Changes line previously set by testStarted event.
-			case "testResult":
+				// Event indicating end of a test or site blocked on synchronization step. Changes line previously set by testStarted event.
+			case "testResult", "testWaiting":
 				app.QueueUpdateDraw(func() {
 					resultLists[graphicEvent.Result.Site][len(resultLists[graphicEvent.Result.Site])-1] = graphicEvent.Result
 					siteBoxes[graphicEvent.Result.Site].Clear()
 					for _, result := range resultLists[graphicEvent.Result.Site] {
-						if result.Retried > 0 {
-							fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v (%v) \n", result.Id, result.Result, result.Label, result.Message, result.Retried+1)
+						if result.Result == test.Waiting {
+							fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v [yellow]%s[white] %v: %v \n", result.Id, result.Result, result.Label, result.Message)
+						} else if result.Retried > 0 {
+							fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v (%v) \n", result.Id, result.Result, result.Label, resultMessage(result), result.Retried+1)
 						} else {
-							fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v \n", result.Id, result.Result, result.Label, result.Message)
+							fmt.Fprintf(siteBoxes[graphicEvent.Result.Site], "%v %s %v: %v \n", result.Id, result.Result, result.Label, resultMessage(result))
 						}
 					}
 					siteBoxes[graphicEvent.Result.Site].ScrollToEnd()
@@ -247,12 +320,42 @@ func (t *TviewInterface) GraphicEventHandler() {
 						// siteBoxes[graphicEvent.Result.Site].SetBackgroundColor(tcell.ColorDarkGreen)
 						resultBoxes[graphicEvent.Result.Site].SetBackgroundColor(tcell.ColorDarkGreen)
 						fmt.Fprintf(resultBoxes[graphicEvent.Result.Site], "%s", graphicEvent.Result.Result)
+					} else if graphicEvent.Result.Result == test.Aborted {
+						resultBoxes[graphicEvent.Result.Site].SetBackgroundColor(tcell.ColorDarkOrange)
+						fmt.Fprintf(resultBoxes[graphicEvent.Result.Site], "%s", graphicEvent.Result.Result)
 					} else {
 						// siteBoxes[graphicEvent.Result.Site].SetBackgroundColor(tcell.ColorDarkRed)
 						resultBoxes[graphicEvent.Result.Site].SetBackgroundColor(tcell.ColorDarkRed)
 						fmt.Fprintf(resultBoxes[graphicEvent.Result.Site], "%s", graphicEvent.Result.Result)
 					}
 				})
+			// Event sent before new configuration is loaded - clears results and device list of previous config
+			case "configReset":
+				app.QueueUpdateDraw(func() {
+					for k := range resultLists {
+						delete(resultLists, k)
+					}
+					for k := range siteStages {
+						delete(siteStages, k)
+					}
+					for k := range unavailableDevices {
+						delete(unavailableDevices, k)
+					}
+					for i, siteBox := range siteBoxes {
+						siteBox.Clear()
+						siteBox.SetTitle(siteTitle(i, siteStages, unavailableDevices))
+					}
+					for _, resultBox := range resultBoxes {
+						resultBox.Clear()
+						resultBox.SetBackgroundColor(tcell.ColorDefault)
+					}
+					sequenceBox.SetTitle(" Sequence ")
+				})
+			// Event informing that active configuration file changed on disk - operator can reload it
+			case "configChanged":
+				app.QueueUpdateDraw(func() {
+					sequenceBox.SetTitle(" Sequence [yellow](config changed on disk - F5 to reload)[white] ")
+				})
 			// Event adding debug information to debug page
 			case "debugInfo":
 				app.QueueUpdateDraw(func() {
@@ -267,8 +370,34 @@ func (t *TviewInterface) GraphicEventHandler() {
 		}
 	}()
 
+	go func() {
+		<-t.stop
+		app.Stop()
+	}()
+
 	// Start tview application
+	defer close(t.stopped)
 	if err := app.SetRoot(masterLayout, true).EnableMouse(true).Run(); err != nil {
 		panic(err)
 	}
 }
+
+// Title of site box - stage of the site if it was assigned and devices unavailable on the site
+func siteTitle(site int, siteStages map[int]int, unavailableDevices map[int][]string) string {
+	title := "Site" + fmt.Sprintf("%v", site)
+	if stage, ok := siteStages[site]; ok {
+		title = "Stage" + fmt.Sprintf("%v", stage) + " " + title
+	}
+	if len(unavailableDevices[site]) > 0 {
+		title += " [red]unavailable: " + strings.Join(unavailableDevices[site], ", ") + "[white]"
+	}
+	return title
+}
+
+// Message of the result followed by its measurements
+func resultMessage(result test.Result) string {
+	if len(result.Measurements) == 0 {
+		return result.Message
+	}
+	return result.Message + " " + result.MeasurementString()
+}
@@ -0,0 +1,487 @@
+package xmodem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol used for file transfer - XMODEM-1K transfers data only, YMODEM also file name and size
+type Protocol int
+
+const (
+	XModem1K Protocol = iota
+	YModem
+)
+
+func (p Protocol) String() string {
+	return [...]string{"xmodem1k", "ymodem"}[p]
+}
+
+// Returns protocol with given name
+func ParseProtocol(name string) (Protocol, bool) {
+	for p := XModem1K; p <= YModem; p++ {
+		if p.String() == strings.ToLower(name) {
+			return p, true
+		}
+	}
+	return XModem1K, false
+}
+
+// Control characters of the protocols
+const (
+	soh byte = 0x01
+	stx byte = 0x02
+	eot byte = 0x04
+	ack byte = 0x06
+	nak byte = 0x15
+	can byte = 0x18
+	crc byte = 'C'
+	sub byte = 0x1A
+)
+
+// Options of transfer - packet timeout is time peer has to answer a packet (or send one), retries is number of times
+// packet is repeated before transfer fails. Progress is called with number of transferred bytes and total size,
+// which is 0 when it is unknown
+type Options struct {
+	Protocol      Protocol
+	PacketTimeout time.Duration
+	Retries       int
+	Progress      func(transferred, total int)
+}
+
+// Error returned when peer cancelled transfer
+var ErrCancelled = errors.New("transfer cancelled by peer")
+
+// State of one transfer over stream
+// Stream Read has to return regularly even when no data arrives (0 bytes and nil error, like serial port with
+// read timeout), so timeouts and cancellation can be handled
+type transfer struct {
+	ctx      context.Context
+	stream   io.ReadWriter
+	options  Options
+	received []byte
+	chunk    []byte
+}
+
+func newTransfer(ctx context.Context, stream io.ReadWriter, options Options) *transfer {
+	if options.PacketTimeout <= 0 {
+		options.PacketTimeout = 10 * time.Second
+	}
+	if options.Progress == nil {
+		options.Progress = func(int, int) {}
+	}
+	return &transfer{
+		ctx:     ctx,
+		stream:  stream,
+		options: options,
+		chunk:   make([]byte, 1100),
+	}
+}
+
+var errTimeout = errors.New("timeout")
+
+// Reads single byte - returns errTimeout when nothing arrived within timeout
+func (t *transfer) readByte(timeout time.Duration) (byte, error) {
+	data, err := t.readFull(1, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// Reads exactly n bytes - returns errTimeout when they didn't arrive within timeout
+func (t *transfer) readFull(n int, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for len(t.received) < n {
+		if err := t.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errTimeout
+		}
+		count, err := t.stream.Read(t.chunk)
+		t.received = append(t.received, t.chunk[:count]...)
+		if err != nil && len(t.received) < n {
+			return nil, err
+		}
+	}
+	data := bytes.Clone(t.received[:n])
+	t.received = t.received[n:]
+	return data, nil
+}
+
+// Drops everything peer sends until line is quiet, so next packet starts clean after an error
+func (t *transfer) purge() {
+	t.received = nil
+	for {
+		if _, err := t.readByte(100 * time.Millisecond); err != nil {
+			t.received = nil
+			return
+		}
+		t.received = nil
+	}
+}
+
+func (t *transfer) write(data ...byte) error {
+	_, err := t.stream.Write(data)
+	return err
+}
+
+// Tells peer transfer is aborted - used when transfer fails on this side
+func (t *transfer) cancel() {
+	t.write(can, can, can)
+}
+
+// Ends transfer that failed - cancelled context and errors on this side cancel transfer on peer as well
+func (t *transfer) fail(err error) error {
+	if errors.Is(err, ErrCancelled) {
+		return err
+	}
+	t.cancel()
+	if t.ctx.Err() != nil {
+		return errors.New("transfer cancelled")
+	}
+	return err
+}
+
+// Checks if CAN is followed by another CAN - single CAN can be line noise
+func (t *transfer) cancelledByPeer() bool {
+	next, err := t.readByte(time.Second)
+	return err == nil && next == can
+}
+
+// CRC-16/XMODEM - polynomial 0x1021, initial value 0
+func crc16(data []byte) uint16 {
+	var value uint16
+	for _, b := range data {
+		value ^= uint16(b) << 8
+		for range 8 {
+			if value&0x8000 != 0 {
+				value = value<<1 ^ 0x1021
+			} else {
+				value <<= 1
+			}
+		}
+	}
+	return value
+}
+
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// Sends data to receiving peer - YMODEM also sends file name and size
+func Send(ctx context.Context, stream io.ReadWriter, name string, data []byte, options Options) error {
+	t := newTransfer(ctx, stream, options)
+	useCrc, err := t.waitForReceiver()
+	if err != nil {
+		return t.fail(err)
+	}
+
+	if t.options.Protocol == YModem {
+		header := append([]byte(name), 0)
+		header = append(header, []byte(strconv.Itoa(len(data)))...)
+		if len(header) > 128 {
+			return t.fail(errors.New("file name too long for YMODEM header"))
+		}
+		if err := t.sendPacket(0, header, useCrc); err != nil {
+			return t.fail(err)
+		}
+		if useCrc, err = t.waitForReceiver(); err != nil {
+			return t.fail(err)
+		}
+	}
+
+	sequence := byte(1)
+	for offset := 0; offset < len(data); sequence++ {
+		t.options.Progress(offset, len(data))
+		size := min(1024, len(data)-offset)
+		if err := t.sendPacket(sequence, data[offset:offset+size], useCrc); err != nil {
+			return t.fail(err)
+		}
+		offset += size
+	}
+	if err := t.sendEndOfTransfer(); err != nil {
+		return t.fail(err)
+	}
+
+	// YMODEM batch ends with empty header
+	if t.options.Protocol == YModem {
+		if useCrc, err = t.waitForReceiver(); err != nil {
+			return t.fail(err)
+		}
+		if err := t.sendPacket(0, nil, useCrc); err != nil {
+			return t.fail(err)
+		}
+	}
+	t.options.Progress(len(data), len(data))
+	return nil
+}
+
+// Waits for receiver to ask for transfer - 'C' requests CRC, NAK arithmetic checksum
+func (t *transfer) waitForReceiver() (bool, error) {
+	deadline := time.Now().Add(t.options.PacketTimeout * time.Duration(t.options.Retries+1))
+	for time.Now().Before(deadline) {
+		received, err := t.readByte(time.Until(deadline))
+		if err != nil {
+			break
+		}
+		switch received {
+		case crc:
+			return true, nil
+		case nak:
+			return false, nil
+		case can:
+			if t.cancelledByPeer() {
+				return false, ErrCancelled
+			}
+		}
+	}
+	if err := t.ctx.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("receiver didn't start transfer")
+}
+
+// Sends packet and waits until receiver acknowledges it - packet is repeated on NAK or timeout up to retry count
+// Data is padded to 128 or 1024 bytes
+func (t *transfer) sendPacket(sequence byte, data []byte, useCrc bool) error {
+	header, size := stx, 1024
+	if len(data) <= 128 {
+		header, size = soh, 128
+	}
+	padding := sub
+	if sequence == 0 {
+		padding = 0
+	}
+	packet := []byte{header, sequence, ^sequence}
+	packet = append(packet, data...)
+	packet = append(packet, bytes.Repeat([]byte{padding}, size-len(data))...)
+	if useCrc {
+		value := crc16(packet[3:])
+		packet = append(packet, byte(value>>8), byte(value))
+	} else {
+		packet = append(packet, checksum(packet[3:]))
+	}
+
+	var lastError string
+	for range t.options.Retries + 1 {
+		if err := t.write(packet...); err != nil {
+			return err
+		}
+		response, err := t.readByte(t.options.PacketTimeout)
+		switch {
+		case err == errTimeout:
+			lastError = "timeout"
+			continue
+		case err != nil:
+			return err
+		case response == ack:
+			return nil
+		case response == can && t.cancelledByPeer():
+			return ErrCancelled
+		case response == nak:
+			lastError = "NAK"
+		default:
+			lastError = fmt.Sprintf("unexpected response 0x%02X", response)
+			t.purge()
+		}
+	}
+	return fmt.Errorf("packet %v not acknowledged after %v retries, last response: %s", sequence, t.options.Retries, lastError)
+}
+
+// Sends EOT until receiver acknowledges it - YMODEM receiver NAKs the first one
+func (t *transfer) sendEndOfTransfer() error {
+	for range t.options.Retries + 1 {
+		if err := t.write(eot); err != nil {
+			return err
+		}
+		response, err := t.readByte(t.options.PacketTimeout)
+		switch {
+		case err == errTimeout:
+			continue
+		case err != nil:
+			return err
+		case response == ack:
+			return nil
+		case response == can && t.cancelledByPeer():
+			return ErrCancelled
+		}
+	}
+	return errors.New("end of transfer not acknowledged")
+}
+
+// Receives file from sending peer - returns file name (empty for XMODEM-1K) and data
+// XMODEM-1K data is padded by sender, so trailing padding characters are removed
+// Only one file is accepted from YMODEM batch
+func Receive(ctx context.Context, stream io.ReadWriter, options Options) (string, []byte, error) {
+	t := newTransfer(ctx, stream, options)
+	var name string
+	size := -1
+
+	if t.options.Protocol == YModem {
+		header, err := t.receivePacket(0, crc)
+		if err != nil {
+			return "", nil, t.fail(err)
+		}
+		if header == nil || header[0] == 0 {
+			t.write(ack)
+			return "", nil, errors.New("sender has no file to send")
+		}
+		name, size = parseHeader(header)
+		t.write(ack)
+	}
+
+	var data []byte
+	sequence := byte(1)
+	start := crc
+	for {
+		t.options.Progress(len(data), max(size, 0))
+		packet, err := t.receivePacket(sequence, start)
+		if err != nil {
+			return "", nil, t.fail(err)
+		}
+		start = 0
+		if packet == nil {
+			break
+		}
+		data = append(data, packet...)
+		sequence++
+		if err := t.write(ack); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if t.options.Protocol == YModem {
+		// Second EOT is acknowledged and sender is asked for next file, which has to be empty header ending batch
+		if err := t.write(nak); err != nil {
+			return "", nil, err
+		}
+		if received, err := t.readByte(t.options.PacketTimeout); err != nil || received != eot {
+			return "", nil, t.fail(errors.New("second EOT not received"))
+		}
+		t.write(ack)
+		header, err := t.receivePacket(0, crc)
+		if err != nil {
+			return "", nil, t.fail(err)
+		}
+		if header != nil && header[0] != 0 {
+			return "", nil, t.fail(errors.New("only one file can be received"))
+		}
+		t.write(ack)
+		if size >= 0 && size <= len(data) {
+			data = data[:size]
+		}
+	} else {
+		t.write(ack)
+		data = bytes.TrimRight(data, string(sub))
+	}
+	t.options.Progress(len(data), len(data))
+	return name, data, nil
+}
+
+// Parses YMODEM header "name\0size ..." - size is -1 when sender didn't send it
+func parseHeader(header []byte) (string, int) {
+	name, rest, _ := bytes.Cut(header, []byte{0})
+	fields := strings.Fields(string(bytes.TrimRight(rest, "\x00")))
+	size := -1
+	if len(fields) > 0 {
+		if parsed, err := strconv.Atoi(fields[0]); err == nil {
+			size = parsed
+		}
+	}
+	return string(name), size
+}
+
+// Receives packet with given sequence number - start byte other than 0 is sent first and repeated until sender
+// responds, to start transfer. Returns nil data when sender ended transfer with EOT. Damaged packets are NAKed
+// and repeated packets acknowledged again, every error counts against retries
+func (t *transfer) receivePacket(sequence byte, start byte) ([]byte, error) {
+	request := nak
+	if start != 0 {
+		request = start
+		if err := t.write(start); err != nil {
+			return nil, err
+		}
+	}
+	errorCount := 0
+	retry := func(reason string) error {
+		errorCount++
+		if errorCount > t.options.Retries {
+			return fmt.Errorf("packet %v not received after %v retries, last error: %s", sequence, t.options.Retries, reason)
+		}
+		t.purge()
+		return t.write(request)
+	}
+
+	for {
+		header, err := t.readByte(t.options.PacketTimeout)
+		if err == errTimeout {
+			if err := retry("timeout"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var size int
+		switch header {
+		case soh:
+			size = 128
+		case stx:
+			size = 1024
+		case eot:
+			return nil, nil
+		case can:
+			if t.cancelledByPeer() {
+				return nil, ErrCancelled
+			}
+			continue
+		default:
+			// Line noise between packets
+			continue
+		}
+
+		body, err := t.readFull(size+4, t.options.PacketTimeout)
+		if err == errTimeout {
+			if err := retry("incomplete packet"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := body[2 : 2+size]
+		value := uint16(body[2+size])<<8 | uint16(body[3+size])
+		switch {
+		case body[0] != ^body[1]:
+			err = retry("damaged sequence number")
+		case value != crc16(data):
+			err = retry("CRC error")
+		case body[0] == sequence-1 && sequence != 0:
+			// Acknowledgement of previous packet was lost - sender repeats it
+			err = t.write(ack)
+		case body[0] != sequence:
+			return nil, fmt.Errorf("packet %v received instead of %v", body[0], sequence)
+		default:
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		request = nak
+	}
+}
@@ -0,0 +1,295 @@
+package xmodem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// One end of in-memory serial line - Read returns 0 bytes when nothing arrives within short time,
+// like serial port with read timeout. Filter can change data written by this end before peer receives it
+type endpoint struct {
+	in     chan byte
+	out    chan byte
+	mutex  sync.Mutex
+	filter func(data []byte) []byte
+}
+
+// Returns two connected ends of serial line
+func newLine() (*endpoint, *endpoint) {
+	first, second := make(chan byte, 1<<16), make(chan byte, 1<<16)
+	return &endpoint{in: first, out: second}, &endpoint{in: second, out: first}
+}
+
+func (e *endpoint) Read(p []byte) (int, error) {
+	select {
+	case b := <-e.in:
+		p[0] = b
+	case <-time.After(10 * time.Millisecond):
+		return 0, nil
+	}
+	n := 1
+	for n < len(p) {
+		select {
+		case b := <-e.in:
+			p[n] = b
+			n++
+		default:
+			return n, nil
+		}
+	}
+	return n, nil
+}
+
+func (e *endpoint) Write(p []byte) (int, error) {
+	e.mutex.Lock()
+	data := p
+	if e.filter != nil {
+		data = e.filter(bytes.Clone(p))
+	}
+	e.mutex.Unlock()
+	for _, b := range data {
+		e.out <- b
+	}
+	return len(p), nil
+}
+
+func (e *endpoint) setFilter(filter func(data []byte) []byte) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.filter = filter
+}
+
+// Waits until peer writes given byte, failing test after timeout
+func (e *endpoint) expect(t *testing.T, expected byte) {
+	t.Helper()
+	select {
+	case b := <-e.in:
+		if b != expected {
+			t.Fatalf("Received 0x%02X instead of 0x%02X", b, expected)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("0x%02X not received", expected)
+	}
+}
+
+// Returns data which isn't padded to packet size and doesn't end with padding character
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	data[size-1] = 0x55
+	return data
+}
+
+type transferResult struct {
+	name string
+	data []byte
+	err  error
+}
+
+// Runs Receive on one end of line in background
+func receiveAsync(ctx context.Context, stream *endpoint, options Options) chan transferResult {
+	results := make(chan transferResult, 1)
+	go func() {
+		name, data, err := Receive(ctx, stream, options)
+		results <- transferResult{name: name, data: data, err: err}
+	}()
+	return results
+}
+
+func TestSendReceive(t *testing.T) {
+	for _, protocol := range []Protocol{XModem1K, YModem} {
+		t.Run(protocol.String(), func(t *testing.T) {
+			sender, receiver := newLine()
+			options := Options{Protocol: protocol, PacketTimeout: time.Second, Retries: 3}
+			data := testData(3000)
+			results := receiveAsync(context.Background(), receiver, options)
+
+			if err := Send(context.Background(), sender, "firmware.bin", data, options); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			result := <-results
+			if result.err != nil {
+				t.Fatalf("Receive failed: %v", result.err)
+			}
+			if !bytes.Equal(result.data, data) {
+				t.Errorf("Received %v B differing from %v B sent", len(result.data), len(data))
+			}
+			expectedName := ""
+			if protocol == YModem {
+				expectedName = "firmware.bin"
+			}
+			if result.name != expectedName {
+				t.Errorf("Received name %q, expected %q", result.name, expectedName)
+			}
+		})
+	}
+}
+
+func TestDamagedPacketIsRetransmitted(t *testing.T) {
+	sender, receiver := newLine()
+	options := Options{Protocol: XModem1K, PacketTimeout: time.Second, Retries: 3}
+	data := testData(2048)
+
+	// First data packet is damaged on the line, receiver has to NAK it and sender repeat it
+	damaged := 0
+	sender.setFilter(func(packet []byte) []byte {
+		if damaged == 0 && len(packet) > 128 {
+			damaged++
+			packet[10] ^= 0xFF
+		}
+		return packet
+	})
+	results := receiveAsync(context.Background(), receiver, options)
+
+	if err := Send(context.Background(), sender, "", data, options); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	result := <-results
+	if result.err != nil {
+		t.Fatalf("Receive failed: %v", result.err)
+	}
+	if damaged != 1 {
+		t.Fatalf("Packet wasn't damaged")
+	}
+	if !bytes.Equal(result.data, data) {
+		t.Errorf("Received %v B differing from %v B sent", len(result.data), len(data))
+	}
+}
+
+func TestSendNakRetries(t *testing.T) {
+	sender, peer := newLine()
+	options := Options{Protocol: XModem1K, PacketTimeout: 500 * time.Millisecond, Retries: 2}
+	peer.Write([]byte{crc})
+
+	// Peer rejects every packet - sender gives up after retries
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buffer := make([]byte, 2048)
+		for range options.Retries + 1 {
+			received := 0
+			for received < 1029 {
+				n, _ := peer.Read(buffer[received:])
+				received += n
+			}
+			peer.Write([]byte{nak})
+		}
+	}()
+
+	err := Send(context.Background(), sender, "", testData(1000), options)
+	<-done
+	if err == nil || !strings.Contains(err.Error(), "last response: NAK") {
+		t.Fatalf("Expected error after NAKs, got %v", err)
+	}
+	// Sender cancels transfer on peer when it fails
+	peer.expect(t, can)
+}
+
+func TestTimeout(t *testing.T) {
+	options := Options{Protocol: XModem1K, PacketTimeout: 100 * time.Millisecond, Retries: 2}
+
+	t.Run("send", func(t *testing.T) {
+		sender, _ := newLine()
+		start := time.Now()
+		err := Send(context.Background(), sender, "", testData(100), options)
+		if err == nil || !strings.Contains(err.Error(), "receiver didn't start transfer") {
+			t.Fatalf("Expected timeout error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Timeout took %v", elapsed)
+		}
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		receiver, peer := newLine()
+		_, _, err := Receive(context.Background(), receiver, options)
+		if err == nil || !strings.Contains(err.Error(), "last error: timeout") {
+			t.Fatalf("Expected timeout error, got %v", err)
+		}
+		// Receiver asks for transfer, repeats request on every timeout and cancels transfer when it gives up
+		for range options.Retries + 1 {
+			peer.expect(t, crc)
+		}
+		peer.expect(t, can)
+	})
+}
+
+func TestCancelledByPeer(t *testing.T) {
+	options := Options{Protocol: XModem1K, PacketTimeout: time.Second, Retries: 3}
+
+	t.Run("send", func(t *testing.T) {
+		sender, peer := newLine()
+		peer.Write([]byte{crc})
+		go func() {
+			// First packet is answered by cancel
+			buffer := make([]byte, 2048)
+			for {
+				if n, _ := peer.Read(buffer); n > 0 {
+					peer.Write([]byte{can, can})
+					return
+				}
+			}
+		}()
+		err := Send(context.Background(), sender, "", testData(100), options)
+		if !errors.Is(err, ErrCancelled) {
+			t.Fatalf("Expected cancel by peer, got %v", err)
+		}
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		receiver, peer := newLine()
+		results := receiveAsync(context.Background(), receiver, options)
+		peer.expect(t, crc)
+		peer.Write([]byte{can, can})
+		result := <-results
+		if !errors.Is(result.err, ErrCancelled) {
+			t.Fatalf("Expected cancel by peer, got %v", result.err)
+		}
+	})
+
+	t.Run("single CAN is noise", func(t *testing.T) {
+		sender, receiver := newLine()
+		receiver.Write([]byte{can})
+		results := receiveAsync(context.Background(), receiver, options)
+		data := testData(500)
+		if err := Send(context.Background(), sender, "", data, options); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		result := <-results
+		if result.err != nil || !bytes.Equal(result.data, data) {
+			t.Fatalf("Transfer failed after single CAN: %v", result.err)
+		}
+	})
+}
+
+func TestCancelledTransferCancelsPeer(t *testing.T) {
+	sender, receiver := newLine()
+	options := Options{Protocol: XModem1K, PacketTimeout: time.Second, Retries: 3}
+
+	// Receiver is cancelled after first packet - sender has to stop on CAN instead of waiting for timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiveOptions := options
+	receiveOptions.Progress = func(transferred, total int) {
+		if transferred > 0 {
+			cancel()
+		}
+	}
+	results := receiveAsync(ctx, receiver, receiveOptions)
+
+	err := Send(context.Background(), sender, "", testData(4096), options)
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("Expected cancel by peer, got %v", err)
+	}
+	result := <-results
+	if result.err == nil || result.err.Error() != "transfer cancelled" {
+		t.Fatalf("Expected cancelled receive, got %v", result.err)
+	}
+}
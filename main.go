@@ -7,9 +7,12 @@ import (
 	"checkerbox/internal/event"
 	"checkerbox/internal/test"
 	"checkerbox/internal/userinterface"
-	"checkerbox/internal/util"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,14 +22,24 @@ import (
 
 type applicationContext struct {
 	ctxMutex           sync.Mutex
+	options            runOptions
 	configSource       string
+	activeConfig       string
+	runningSites       int
+	sequences          sync.WaitGroup
+	watcher            *configWatcher
 	noError            bool
 	appSettings        *config.AppSettings
 	config             *config.Config
 	devices            []device.Device
-	sequenceEventLists map[int]*util.Queue[event.Event]
+	stages             []config.StageSettings
+	sequenceEventLists map[int]map[int]siteSequence
+	syncManager        *device.SyncManager
+	dispatcher         *device.Dispatcher
+	siteCancels        map[int]context.CancelFunc
 	eventBus           *event.EventBus
 	deviceErrors       []error
+	deviceStatus       chan device.StatusEvent
 	graphicInterface   userinterface.GraphicInterface
 	uiReturnChannel    chan event.ControlEvent
 	reportDatabase     *gorm.DB
@@ -34,161 +47,227 @@ type applicationContext struct {
 }
 
 func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// Runs application with given options - returns process exit code
+func runApplication(options runOptions) int {
 	// Loading basic app configuration - site number and UI engine
-	var ctx applicationContext
-	loadAppSettings(&ctx)
+	ctx := &applicationContext{options: options}
+	if err := loadAppSettings(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitConfigError
+	}
 
-	// Start main event loop if graphic interface was specified, otherwise load deafult config and start execution
-	if ctx.graphicInterface != nil {
-	out:
-		for receivedEvent := range ctx.uiReturnChannel {
-			switch receivedEvent.Type {
-			// Event that starts sequence goroutines - sequence execution
-			case "START":
-				for i, sequenceEventList := range ctx.sequenceEventLists {
-					go handleSequence(*sequenceEventList, &ctx, i)
-				}
-			// Event finnishing application execution
-			case "QUIT":
-				break out
-			// Event picking configuration file for sequence - reloads all configuration for application
-			case "CONFIGPICK":
-				newCtx := applicationContext{}
-				newCtx.graphicInterface = ctx.graphicInterface
-				newCtx.uiReturnChannel = ctx.uiReturnChannel
-				ctx = newCtx
-				ctx.configSource = receivedEvent.Data.(string)
-				loadAppSettings(&ctx)
-				reloadConfiguration(&ctx, "./config/"+receivedEvent.Data.(string))
-			// Event setting NoError mode
-			case "NOERROR":
-				ctx.noError = !ctx.noError
+	// Start main event loop if graphic interface was specified, otherwise load config and start execution
+	if ctx.graphicInterface == nil {
+		// Runs sequence on every site, waits for completion and exits with code describing overall result
+		return runHeadless(ctx, options.configPath)
+	}
+
+	// Active config file is optionally watched - operator is offered reload when it changes on disk
+	if options.watch {
+		ctx.watcher = &configWatcher{}
+		go ctx.watcher.run(2*time.Second, ctx.uiReturnChannel)
+	}
+
+	// Config given on command line is loaded right away, otherwise operator picks one in UI
+	if options.loadConfig {
+		switchConfiguration(ctx, options.configPath)
+	}
+	handleControlEvents(ctx)
+	return exitPass
+}
+
+// Main event loop - handles control events sent by UI until it asks to quit
+func handleControlEvents(ctx *applicationContext) {
+	for receivedEvent := range ctx.uiReturnChannel {
+		switch receivedEvent.Type {
+		// Event that starts sequence goroutines - sequence execution
+		case "START":
+			startSequences(ctx)
+		// Event finnishing application execution - running sequences are aborted and waited for before devices
+		// are closed and UI is stopped, UI has to keep receiving events published by them until they end
+		case "QUIT":
+			abortSequences(ctx, -1)
+			waitForSequences(ctx)
+			shutdownDevices(ctx)
+			ctx.graphicInterface.Stop()
+			return
+		// Event picking configuration file for sequence - replaces devices and sequences of previous config
+		case "CONFIGPICK":
+			switchConfiguration(ctx, filepath.Join(filepath.Dir(ctx.options.configPath), receivedEvent.Data.(string)))
+		// Event reloading active configuration file
+		case "CONFIGRELOAD":
+			if ctx.activeConfig != "" {
+				switchConfiguration(ctx, ctx.activeConfig)
 			}
+		// Event sent by watcher when active configuration file changed on disk
+		case "CONFIGCHANGED":
+			SendConfigChangedEvent(ctx, receivedEvent.Data.(string))
+			SendDebugInfoEvent(ctx, *data.NewCustomLog("mainloop", "Configuration file changed on disk: "+receivedEvent.Data.(string), 99, data.WARNING))
+		// Event aborting running sequence - on every site or on site passed as data
+		case "ABORT":
+			site, ok := receivedEvent.Data.(int)
+			if !ok {
+				site = -1
+			}
+			abortSequences(ctx, site)
+		// Event setting NoError mode
+		case "NOERROR":
+			ctx.noError = !ctx.noError
 		}
-	} else {
-		// Default execution when graphic engine is not specified
-		// Starts sequence execution
-		reloadConfiguration(&ctx, "./config/config.yml")
-		for i, sequenceEventList := range ctx.sequenceEventLists {
-			go handleSequence(*sequenceEventList, &ctx, i)
+	}
+}
+
+// Waits until every sequence goroutine ends - control events sent by UI in the meantime are dropped, so UI isn't blocked
+func waitForSequences(ctx *applicationContext) {
+	done := make(chan struct{})
+	go func() {
+		ctx.sequences.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.uiReturnChannel:
 		}
-		// TODO change mechanism for no UI execution - add waitgroup to sequence handlers
-		time.Sleep(time.Second * 10)
 	}
 }
 
-// Function handling sequence execution - receives event queue and sends events to specified modules, receives results and handles them accordingly sending them to UI or printing them to screen
-func handleSequence(sequenceEventsList util.Queue[event.Event], ctx *applicationContext, siteId int) {
-	// Create return channel for receiving results from modules
-	// It has to be buffered, otherwise gouroutines would lock eachother while waiting for response from modules
-	// We dont have to worry about deadlocks because handler sends this return channel in event itself so modules won't cross-talk with different handlers
-	siteResultChannel := make(chan test.Result, 100)
-	// Flag indicating failed sequence - needed because with noError mode it doesnt necessarily mean end of sequence execution
-	sequenceFailed := false
-
-	// Set report instance for db writing
-	report := data.NewReport()
+// Starts sequence goroutine for every site of every stage - running sites are counted so config isn't switched under them
+// Devices are reset and health checked before run
+func startSequences(ctx *applicationContext) {
 	ctx.ctxMutex.Lock()
-	report.SetSource(ctx.configSource)
+	running := ctx.runningSites > 0
 	ctx.ctxMutex.Unlock()
-	report.SetSite(siteId)
-	report.AppendReportString("Sequence Started \n")
-
-	// Looping over events in queue
-	for range sequenceEventsList.Len() {
-		// Taking one event and setting return channel
-		singleSequenceEvent := sequenceEventsList.Dequeue()
-		singleSequenceEvent.ReturnChannel = siteResultChannel
-		var result test.Result
-		// Looping with one event however maany retries where specified by loaded config
-		for retried := range singleSequenceEvent.Data.(event.SequenceEvent).Retry {
-
-			// Publish sequence event, UI events and send logging data to database
-			ctx.ctxMutex.Lock()
-			ctx.eventBus.Publish(singleSequenceEvent)
-			sequenceEventForUI := singleSequenceEvent.Data.(event.SequenceEvent)
-			SendTestStartedEvent(ctx, sequenceEventForUI.Id, sequenceEventForUI.Site, sequenceEventForUI.Label)
-			log := data.NewCustomLog("mainloop", sequenceEventForUI.Label+"| Test started", sequenceEventForUI.Site, data.INFO)
-			SendDebugInfoEvent(ctx, *log)
-			ctx.logDatabase.Create(log)
-			ctx.ctxMutex.Unlock()
-
-			// Select on response to return channel or timeout on specified timeout time in config
-			select {
-			case result = <-siteResultChannel:
-				result.Retried = retried
-			case <-time.After(time.Millisecond * time.Duration(sequenceEventForUI.Timeout)):
-				result = test.Result{
-					Result:  test.Error,
-					Site:    sequenceEventForUI.Site,
-					Id:      sequenceEventForUI.Id,
-					Label:   sequenceEventForUI.Label,
-					Message: "Timeout",
-				}
-			}
-			// Log result data (UI and db)
-			ctx.ctxMutex.Lock()
-			SendTestResultEvent(ctx, result)
-			var logType data.LogType
-			if result.Result == test.Error {
-				logType = data.ERROR
-			} else {
-				logType = data.INFO
-			}
-			log = data.NewCustomLog(sequenceEventForUI.DeviceName, result.Label+"|Test finished with result: "+result.Message+" On retry: "+fmt.Sprintf("%v", result.Retried), result.Site, logType)
-			ctx.logDatabase.Create(log)
-			SendDebugInfoEvent(ctx, *log)
-			ctx.ctxMutex.Unlock()
-			// If no graphic engine, print to standard output
-			if ctx.graphicInterface == nil {
-				fmt.Println(result)
-			}
+	if running {
+		return
+	}
+	prepareDevices(ctx)
 
-			// If result is not fail - error, pass, done - break retry loop and continue
-			if result.Result == test.Pass || result.Result == test.Error || result.Result == test.Done {
-				break
-			}
-		}
-		// Append report with data from test
-		report.AppendReportString(fmt.Sprintf("%v %s %v: %v (%v) \n", result.Id, result.Result, result.Label, result.Message, result.Retried+1))
-		// Based on test result and no error mode status either finish execution or continue with overall result as fail
-		if (result.Result == test.Fail || result.Result == test.Error) && !ctx.noError {
-			sequenceFailed = true
-			sequenceEventsList.Flush()
-			SendSequenceEndEvent(ctx, test.Fail, siteId)
-			report.SetOverallResult(test.Fail)
-			break
-		} else if (result.Result == test.Fail || result.Result == test.Error) && ctx.noError {
-			sequenceFailed = true
-			report.SetOverallResult(test.Fail)
+	ctx.ctxMutex.Lock()
+	defer ctx.ctxMutex.Unlock()
+	for stage, stageEventLists := range ctx.sequenceEventLists {
+		for site, sequenceEventList := range stageEventLists {
+			ctx.runningSites++
+			ctx.sequences.Add(1)
+			go func() {
+				defer ctx.sequences.Done()
+				handleSequence(sequenceEventList, ctx, stage, site)
+				ctx.ctxMutex.Lock()
+				ctx.runningSites--
+				ctx.ctxMutex.Unlock()
+			}()
 		}
 	}
-	// Send sequence end events for UI and send report data to db
-	if !sequenceFailed {
-		SendSequenceEndEvent(ctx, test.Pass, siteId)
-		report.SetOverallResult(test.Pass)
-	} else {
-		SendSequenceEndEvent(ctx, test.Fail, siteId)
-		report.SetOverallResult(test.Fail)
-	}
+}
+
+// Switches application to another config file (or reloads active one) without restarting UI
+// Refused while sequence is running. Invalid config is reported and previous config stays loaded
+func switchConfiguration(ctx *applicationContext, path string) {
 	ctx.ctxMutex.Lock()
-	SendDBData(ctx, report)
+	running := ctx.runningSites > 0
 	ctx.ctxMutex.Unlock()
+	if running {
+		SendDebugInfoEvent(ctx, *data.NewCustomLog("mainloop", "Configuration can't be changed while sequence is running", 99, data.WARNING))
+		return
+	}
+	if err := config.JoinValidationErrors(config.ValidateConfigFile(path, *ctx.appSettings, checkSiteSequence)); err != nil {
+		SendDebugInfoEvent(ctx, *data.NewCustomLog("mainloop", "Error while loading configuration: "+err.Error(), 99, data.ERROR))
+		return
+	}
+
+	shutdownDevices(ctx)
+	SendConfigResetEvent(ctx)
+	ctx.configSource = filepath.Base(path)
+	ctx.activeConfig = path
+	if ctx.watcher != nil {
+		ctx.watcher.setPath(path)
+	}
+	if err := reloadConfiguration(ctx, path); err != nil {
+		SendDebugInfoEvent(ctx, *data.NewCustomLog("mainloop", "Error while loading configuration: "+err.Error(), 99, data.ERROR))
+	}
+}
+
+// Resets every device before run and checks its health - devices failing it are marked unavailable
+func prepareDevices(ctx *applicationContext) {
+	for _, loadedDevice := range ctx.devices {
+		reportDeviceHealth(ctx, loadedDevice, device.PrepareForRun(loadedDevice))
+	}
+}
+
+// Informs UI and log about device failing health check - healthy device is reported only to UI, so it is shown available again
+func reportDeviceHealth(ctx *applicationContext, checkedDevice device.Device, err error) {
+	if err == nil {
+		SendDeviceHealthEvent(ctx, test.Pass, checkedDevice.GetSite(), checkedDevice.GetName(), "")
+		return
+	}
+	SendDeviceHealthEvent(ctx, test.Error, checkedDevice.GetSite(), checkedDevice.GetName(), err.Error())
+	SendDebugInfoEvent(ctx, *data.NewCustomLog(checkedDevice.GetName(), "Device unavailable: "+err.Error(), checkedDevice.GetSite(), data.ERROR))
+	ctx.logDatabase.Create(data.NewCustomLog(checkedDevice.GetName(), "Device unavailable: "+err.Error(), checkedDevice.GetSite(), data.ERROR))
+}
+
+// Closes devices of loaded config and clears everything built from it
+func shutdownDevices(ctx *applicationContext) {
+	for _, loadedDevice := range ctx.devices {
+		if err := loadedDevice.Close(); err != nil {
+			SendDebugInfoEvent(ctx, *data.NewCustomLog(loadedDevice.GetName(), "Error while closing device: "+err.Error(), loadedDevice.GetSite(), data.ERROR))
+			ctx.logDatabase.Create(data.NewCustomLog(loadedDevice.GetName(), "Error while closing device: "+err.Error(), loadedDevice.GetSite(), data.ERROR))
+		}
+	}
+	ctx.devices = nil
+	ctx.deviceErrors = nil
+	ctx.config = nil
+	ctx.stages = nil
+	ctx.dispatcher = nil
+	ctx.syncManager = nil
+	ctx.sequenceEventLists = make(map[int]map[int]siteSequence)
+}
+
+// Cancels running sequence of given site - negative site cancels sequences on every site
+func abortSequences(ctx *applicationContext, site int) {
+	ctx.ctxMutex.Lock()
+	defer ctx.ctxMutex.Unlock()
+	for cancelSite, cancel := range ctx.siteCancels {
+		if site < 0 || cancelSite == site {
+			cancel()
+		}
+	}
 }
 
-func loadAppSettings(ctx *applicationContext) {
+// Loads app settings overridden by command line options, opens databases in data directory and creates UI on first call
+func loadAppSettings(ctx *applicationContext) error {
 	// Load basic app settings on startup
-	var err error
-	ctx.appSettings = config.NewAppSettings()
-	ctx.sequenceEventLists = make(map[int]*util.Queue[event.Event])
+	appSettings, err := config.NewAppSettings(ctx.options.appPath)
+	if err != nil {
+		return err
+	}
+	if ctx.options.ui != "" {
+		appSettings.Uiengine = ctx.options.ui
+	}
+	if ctx.options.sites > 0 {
+		appSettings.Sites = ctx.options.sites
+	}
+	if err := appSettings.Validate(); err != nil {
+		return err
+	}
+	ctx.appSettings = appSettings
+	ctx.sequenceEventLists = make(map[int]map[int]siteSequence)
+	ctx.siteCancels = make(map[int]context.CancelFunc)
 	ctx.eventBus = event.NewEventBus()
-	ctx.reportDatabase, err = gorm.Open(sqlite.Open("reports.db"), &gorm.Config{})
+	if ctx.deviceStatus == nil {
+		ctx.deviceStatus = make(chan device.StatusEvent, 100)
+		go handleDeviceStatus(ctx)
+	}
+	ctx.reportDatabase, err = gorm.Open(sqlite.Open(filepath.Join(ctx.options.dataDir, "reports.db")), &gorm.Config{})
 	if err != nil {
 		ctx.reportDatabase = nil
 	} else {
-		ctx.reportDatabase.AutoMigrate(&data.Report{})
+		ctx.reportDatabase.AutoMigrate(&data.Report{}, &data.StepResult{}, &data.StepMeasurement{})
 	}
-	ctx.logDatabase, err = gorm.Open(sqlite.Open("log.db"), &gorm.Config{})
+	ctx.logDatabase, err = gorm.Open(sqlite.Open(filepath.Join(ctx.options.dataDir, "log.db")), &gorm.Config{})
 	if err != nil {
 		ctx.logDatabase = nil
 	} else {
@@ -196,7 +275,7 @@ func loadAppSettings(ctx *applicationContext) {
 	}
 	if ctx.graphicInterface == nil {
 		ctx.uiReturnChannel = make(chan event.ControlEvent)
-		ctx.graphicInterface = config.GraphicalInterfaceResolver(*ctx.appSettings, ctx.uiReturnChannel)
+		ctx.graphicInterface = config.GraphicalInterfaceResolver(*ctx.appSettings, filepath.Dir(ctx.options.configPath), ctx.uiReturnChannel)
 	}
 	ctx.noError = false
 
@@ -204,36 +283,37 @@ func loadAppSettings(ctx *applicationContext) {
 		ctx.eventBus.Subscribe("graphicEvent", ctx.graphicInterface.GetEventChannel())
 		go ctx.graphicInterface.GraphicEventHandler()
 	}
+	return nil
 }
 
-func reloadConfiguration(ctx *applicationContext, path string) {
+// Loads specified config file, builds sequence queues and initializes devices
+// Returns error if config file couldn't be loaded - device initialization errors are stored in context
+func reloadConfiguration(ctx *applicationContext, path string) error {
+	// Validate specified config file before anything is loaded - every problem is reported with its position
+	if err := config.JoinValidationErrors(config.ValidateConfigFile(path, *ctx.appSettings, checkSiteSequence)); err != nil {
+		return err
+	}
+
 	// Load specified config file
-	// loadedConfig, err := config.NewConfig("config/config.yml")
-	// if err != nil {
-	// 	log.Fatal(err.Error())
-	// }
 	loadedConfig, err := config.NewConfig(path)
 	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
 	ctx.config = loadedConfig
+	ctx.stages, err = ctx.config.GetStages(*ctx.appSettings)
+	if err != nil {
+		return err
+	}
 
-	// Load sequence events into lists marked with site number
-	for i := 0; i <= ctx.appSettings.Sites-1; i++ {
-		ctx.sequenceEventLists[i] = util.NewQueue[event.Event]()
-		for n, sequenceConfigNode := range ctx.config.GetSequenceConfig() {
-			ctx.sequenceEventLists[i].Enqueue(event.Event{
-				Type: "SequenceEvent",
-				Data: event.SequenceEvent{
-					Id:           uint(n),
-					Label:        sequenceConfigNode.StepLabel,
-					Site:         i,
-					Retry:        sequenceConfigNode.Retry,
-					DeviceName:   sequenceConfigNode.Device,
-					StepSettings: sequenceConfigNode.StepSettings,
-					Timeout:      sequenceConfigNode.Timeout,
-				},
-			})
+	// Load sequence events into lists marked with stage and site number - every stage has its own sequence
+	// Step ids are continuous across setup, sequence and cleanup so every step of site run has unique id
+	for _, stage := range ctx.stages {
+		ctx.sequenceEventLists[stage.Stage] = make(map[int]siteSequence)
+		for _, site := range stage.Sites {
+			ctx.sequenceEventLists[stage.Stage][site], err = buildSiteSequence(stage, site)
+			if err != nil {
+				return fmt.Errorf("Stage %v: %s", stage.Stage, err.Error())
+			}
 		}
 	}
 
@@ -243,42 +323,95 @@ func reloadConfiguration(ctx *applicationContext, path string) {
 	SendDebugInfoEvent(ctx, *data.NewCustomLog("mainloop", "Configuration loading started", 99, data.INFO))
 	ctx.logDatabase.Create(data.NewCustomLog("mainloop", "Configuration loading started", 99, data.INFO))
 
+	// Inform UI which stage every site belongs to
+	for _, stage := range ctx.stages {
+		for _, site := range stage.Sites {
+			SendSiteStageEvent(ctx, stage.Stage, site)
+		}
+	}
+
+	// Sequence devices of all sites share synchronization primitives
+	stageSites := make(map[int][]int)
+	for _, stage := range ctx.stages {
+		stageSites[stage.Stage] = stage.Sites
+	}
+	ctx.syncManager = device.NewSyncManager(stageSites)
 	for i := 0; i <= ctx.appSettings.Sites-1; i++ {
-		ctx.devices = append(ctx.devices, device.NewSequenceDevice(i))
+		ctx.devices = append(ctx.devices, device.NewSequenceDevice(i, ctx.syncManager))
+	}
+	// Init individual device based on hardware config of every stage
+	for _, stage := range ctx.stages {
+		initStageDevices(ctx, stage)
+	}
+
+	// Register device modules in dispatcher which routes every step only to device instance it addresses
+	ctx.dispatcher = device.NewDispatcher()
+	for _, device := range ctx.devices {
+		if err := ctx.dispatcher.Register(device); err != nil {
+			return err
+		}
+	}
+
+	// Start goroutines from device modules that handle events sent
+	for _, device := range ctx.devices {
+		go device.SequenceEventHandler()
+	}
+	return nil
+}
+
+// Reports status changes published by devices themselves, like lost and restored connection, in UI and log
+func handleDeviceStatus(ctx *applicationContext) {
+	for status := range ctx.deviceStatus {
+		if status.Available {
+			SendDeviceHealthEvent(ctx, test.Pass, status.Site, status.Name, "")
+			SendDebugInfoEvent(ctx, *data.NewCustomLog(status.Name, status.Message, status.Site, data.INFO))
+			ctx.logDatabase.Create(data.NewCustomLog(status.Name, status.Message, status.Site, data.INFO))
+		} else {
+			SendDeviceHealthEvent(ctx, test.Error, status.Site, status.Name, status.Message)
+			SendDebugInfoEvent(ctx, *data.NewCustomLog(status.Name, status.Message, status.Site, data.ERROR))
+			ctx.logDatabase.Create(data.NewCustomLog(status.Name, status.Message, status.Site, data.ERROR))
+		}
+		if ctx.graphicInterface == nil {
+			fmt.Fprintf(os.Stderr, "Site %v %s: %s\n", status.Site, status.Name, status.Message)
+		}
 	}
-	// Init individual device based on config
-	// TODO - after UI design - send UI events based on succesful or unsuccesful initialization instead of printing
-	// TODO - add check if device initialized are out of site number spec
-	for _, deviceDeclaration := range ctx.config.GetHardwareConfig() {
+}
+
+// Initializes devices declared in hardware section of given stage
+func initStageDevices(ctx *applicationContext, stage config.StageSettings) {
+	for _, deviceDeclaration := range stage.Hardware {
 		initializedDevice, initDeviceErrorTable := config.DeviceEntryResolver(deviceDeclaration)
+		deviceName := deviceDeclaration.GetInstanceName()
+		if initializedDevice != nil {
+			if err := initializedDevice.Open(); err != nil {
+				initializedDevice = nil
+				initDeviceErrorTable = append(initDeviceErrorTable, err)
+			}
+		}
 
 		deviceInitErrorString := ""
-		for _, err = range initDeviceErrorTable {
+		for _, err := range initDeviceErrorTable {
 			deviceInitErrorString += err.Error() + "\n"
 		}
 
 		if initializedDevice != nil {
+			initializedDevice.SetStatusChannel(ctx.deviceStatus)
 			ctx.devices = append(ctx.devices, initializedDevice)
-			SendDeviceInitEvent(ctx, test.Pass, deviceDeclaration.Site, deviceDeclaration.DeviceName)
-			SendDebugInfoEvent(ctx, *data.NewCustomLog(deviceDeclaration.DeviceName, "Device initiated", deviceDeclaration.Site, data.INFO))
-			ctx.logDatabase.Create(data.NewCustomLog(deviceDeclaration.DeviceName, "Device initiated", deviceDeclaration.Site, data.INFO))
+			SendDeviceInitEvent(ctx, test.Pass, deviceDeclaration.Site, deviceName)
+			initMessage := "Device initiated"
+			if identifier, ok := initializedDevice.(device.Identifier); ok {
+				initMessage += ": " + identifier.Identity()
+			}
+			SendDebugInfoEvent(ctx, *data.NewCustomLog(deviceName, initMessage, deviceDeclaration.Site, data.INFO))
+			ctx.logDatabase.Create(data.NewCustomLog(deviceName, initMessage, deviceDeclaration.Site, data.INFO))
+			reportDeviceHealth(ctx, initializedDevice, device.CheckHealth(initializedDevice))
 		} else {
-			SendDeviceInitEvent(ctx, test.Error, deviceDeclaration.Site, deviceDeclaration.DeviceName)
-			SendDebugInfoEvent(ctx, *data.NewCustomLog(deviceDeclaration.DeviceName, "Error while initializing device:"+deviceInitErrorString, deviceDeclaration.Site, data.ERROR))
-			ctx.logDatabase.Create(data.NewCustomLog(deviceDeclaration.DeviceName, "Error while initializing device:"+deviceInitErrorString, deviceDeclaration.Site, data.ERROR))
+			ctx.deviceErrors = append(ctx.deviceErrors, errors.New(deviceName+" on site "+fmt.Sprintf("%v", deviceDeclaration.Site)+": "+strings.TrimSpace(deviceInitErrorString)))
+			SendDeviceInitEvent(ctx, test.Error, deviceDeclaration.Site, deviceName)
+			SendDebugInfoEvent(ctx, *data.NewCustomLog(deviceName, "Error while initializing device:"+deviceInitErrorString, deviceDeclaration.Site, data.ERROR))
+			ctx.logDatabase.Create(data.NewCustomLog(deviceName, "Error while initializing device:"+deviceInitErrorString, deviceDeclaration.Site, data.ERROR))
 		}
 	}
-
-	// Instantiate variables regarding event structure
-	// Subsribe device modules to events of type "SequenceEvent"
-	for _, device := range ctx.devices {
-		ctx.eventBus.Subscribe("SequenceEvent", device.GetEventChannel())
-	}
-
-	// Start goroutines from device modules that handle events sent
-	for _, device := range ctx.devices {
-		go device.SequenceEventHandler()
-	}
 }
 
 func SendDBData(ctx *applicationContext, value any) {
@@ -287,6 +420,12 @@ func SendDBData(ctx *applicationContext, value any) {
 	}
 }
 
+func UpdateDBData(ctx *applicationContext, value any) {
+	if ctx.reportDatabase != nil {
+		ctx.reportDatabase.Save(value)
+	}
+}
+
 func SendDebugInfoEvent(ctx *applicationContext, log data.Log) {
 	ctx.eventBus.Publish(event.Event{
 		Type: "graphicEvent",
@@ -311,13 +450,14 @@ func SendDeviceInitEvent(ctx *applicationContext, result test.ResultType, site i
 	})
 }
 
-func SendSequenceEndEvent(ctx *applicationContext, result test.ResultType, site int) {
+func SendSequenceEndEvent(ctx *applicationContext, result test.ResultType, stage, site int) {
 	ctx.eventBus.Publish(event.Event{
 		Type: "graphicEvent",
 		Data: event.GraphicEvent{
 			Type: "sequenceEnd",
 			Result: test.Result{
 				Result: result,
+				Stage:  stage,
 				Site:   site,
 			},
 		},
@@ -334,12 +474,13 @@ func SendTestResultEvent(ctx *applicationContext, result test.Result) {
 	})
 }
 
-func SendTestStartedEvent(ctx *applicationContext, id uint, site int, label string) {
+func SendTestStartedEvent(ctx *applicationContext, id uint, stage, site int, label string) {
 	ctx.eventBus.Publish(event.Event{
 		Type: "graphicEvent",
 		Data: event.GraphicEvent{
 			Type: "testStarted",
 			Result: test.Result{
+				Stage:   stage,
 				Site:    site,
 				Id:      id,
 				Label:   label,
@@ -349,3 +490,60 @@ func SendTestStartedEvent(ctx *applicationContext, id uint, site int, label stri
 		},
 	})
 }
+
+func SendSiteStageEvent(ctx *applicationContext, stage, site int) {
+	ctx.eventBus.Publish(event.Event{
+		Type: "graphicEvent",
+		Data: event.GraphicEvent{
+			Type: "siteStage",
+			Result: test.Result{
+				Stage: stage,
+				Site:  site,
+			},
+		},
+	})
+}
+
+func SendDeviceHealthEvent(ctx *applicationContext, result test.ResultType, site int, label, message string) {
+	ctx.eventBus.Publish(event.Event{
+		Type: "graphicEvent",
+		Data: event.GraphicEvent{
+			Type: "deviceHealth",
+			Result: test.Result{
+				Result:  result,
+				Label:   label,
+				Site:    site,
+				Message: message,
+			},
+		},
+	})
+}
+
+func SendConfigResetEvent(ctx *applicationContext) {
+	ctx.eventBus.Publish(event.Event{
+		Type: "graphicEvent",
+		Data: event.GraphicEvent{
+			Type: "configReset",
+		},
+	})
+}
+
+func SendConfigChangedEvent(ctx *applicationContext, path string) {
+	ctx.eventBus.Publish(event.Event{
+		Type: "graphicEvent",
+		Data: event.GraphicEvent{
+			Type: "configChanged",
+			Log:  *data.NewCustomLog("mainloop", path, 99, data.WARNING),
+		},
+	})
+}
+
+func SendTestWaitingEvent(ctx *applicationContext, result test.Result) {
+	ctx.eventBus.Publish(event.Event{
+		Type: "graphicEvent",
+		Data: event.GraphicEvent{
+			Type:   "testWaiting",
+			Result: result,
+		},
+	})
+}
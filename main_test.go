@@ -0,0 +1,105 @@
+package main
+
+import (
+	"checkerbox/internal/event"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Graphic interface standing in for tview - like stopped tview application it never returns from handling
+// event received after it was stopped, which blocks publisher of the event
+type blockingInterface struct {
+	ctx           *applicationContext
+	events        chan event.Event
+	stopped       chan struct{}
+	released      chan struct{}
+	runningAtStop int
+}
+
+func (b *blockingInterface) GraphicEventHandler() {
+	for range b.events {
+		select {
+		case <-b.stopped:
+			<-b.released
+		default:
+		}
+	}
+}
+
+func (b *blockingInterface) GetEventChannel() chan event.Event {
+	return b.events
+}
+
+func (b *blockingInterface) Stop() {
+	b.ctx.ctxMutex.Lock()
+	b.runningAtStop = b.ctx.runningSites
+	b.ctx.ctxMutex.Unlock()
+	close(b.stopped)
+}
+
+const quitConfig = `
+sequence:
+- step_label: Long wait
+  retry: 1
+  device: sequence
+  timeout: 20000
+  stepsettings:
+      function: Wait
+      time: 10000
+cleanup:
+- step_label: Cleanup wait
+  retry: 1
+  device: sequence
+  timeout: 1000
+  stepsettings:
+      function: Wait
+      time: 100
+`
+
+func TestQuitDuringRun(t *testing.T) {
+	dir := t.TempDir()
+	appPath, configPath := filepath.Join(dir, "app.yml"), filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(appPath, []byte("sites: 2\nstages: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte(quitConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &applicationContext{options: runOptions{appPath: appPath, configPath: configPath, dataDir: dir}}
+	ui := &blockingInterface{ctx: ctx, events: make(chan event.Event), stopped: make(chan struct{}), released: make(chan struct{})}
+	defer close(ui.released)
+	ctx.graphicInterface = ui
+	ctx.uiReturnChannel = make(chan event.ControlEvent)
+	if err := loadAppSettings(ctx); err != nil {
+		t.Fatalf("Loading app settings failed: %v", err)
+	}
+	switchConfiguration(ctx, configPath)
+	if ctx.config == nil {
+		t.Fatalf("Configuration wasn't loaded")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handleControlEvents(ctx)
+		close(done)
+	}()
+	ctx.uiReturnChannel <- event.ControlEvent{Type: "START"}
+	time.Sleep(200 * time.Millisecond)
+
+	// Sequences are aborted, cleanup still runs and publishes its results before UI is stopped
+	ctx.uiReturnChannel <- event.ControlEvent{Type: "QUIT"}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Quit during run didn't finish")
+	}
+	if ui.runningAtStop != 0 {
+		t.Fatalf("UI stopped while %v sites were running", ui.runningAtStop)
+	}
+	if ctx.devices != nil {
+		t.Fatalf("Devices weren't closed")
+	}
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"checkerbox/internal/config"
+	"checkerbox/internal/data"
+	"checkerbox/internal/device"
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Kinds of sequence steps - sections are compiled into flat list of steps executed by index
+const (
+	// Sends sequence event to device
+	stepAction = iota
+	// Jumps to target step
+	stepJump
+	// Starts repeat block - resets its iteration counter
+	stepLoopStart
+	// Leaves repeat block when iteration count is reached or while condition doesn't hold
+	stepLoopCheck
+)
+
+// Step of site sequence - sequence event sent to device or flow control step, with conditions and failure policy resolved from config
+type sequenceStep struct {
+	kind          int
+	sequenceEvent event.Event
+	label         string
+	condition     config.Condition
+	skipWhen      config.Condition
+	target        int
+	loop          int
+	count         int
+	while         config.Condition
+	onFail        string
+	onFailLabel   string
+	onError       string
+	onErrorLabel  string
+	critical      bool
+	capture       map[string]variableCapture
+	limits        []test.Measurement
+}
+
+// Section of site sequence - steps are executed by index so flow control and policies can jump to labeled steps
+type sequenceSection struct {
+	name   string
+	steps  []sequenceStep
+	labels map[string]int
+}
+
+// Sequence sections of one site - setup runs before main sequence, cleanup always runs after it
+type siteSequence struct {
+	setup   sequenceSection
+	main    sequenceSection
+	cleanup sequenceSection
+}
+
+// State of one site run shared by all sections
+type sequenceRun struct {
+	ctx               *applicationContext
+	stage             int
+	siteId            int
+	siteResultChannel chan test.Result
+	report            *data.Report
+	// Results of executed steps stored under step labels and "last" - used by conditions
+	results map[string]test.ResultType
+	// Variables captured from step results, substituted into settings of later steps
+	variables map[string]string
+	// Number of sequence events dispatched so far - every try is numbered so its result can be told apart from late ones
+	dispatches uint
+}
+
+// Outcome of executing one section of the sequence
+type sectionResult struct {
+	failed  bool
+	stopped bool
+	aborted bool
+}
+
+// Function handling sequence execution - receives site sequence and sends events to specified modules, receives results and handles them accordingly sending them to UI or printing them to screen
+// Setup and main sequence stop on failure (unless noError mode is on), cleanup is executed always - also after failure, abort or timeout
+// Returns overall result of the sequence for given site
+func handleSequence(sequence siteSequence, ctx *applicationContext, stage, siteId int) test.ResultType {
+	// Create return channel for receiving results from modules
+	// It has to be buffered, otherwise gouroutines would lock eachother while waiting for response from modules
+	// We dont have to worry about deadlocks because handler sends this return channel in event itself so modules won't cross-talk with different handlers
+	run := sequenceRun{
+		ctx:               ctx,
+		stage:             stage,
+		siteId:            siteId,
+		siteResultChannel: make(chan test.Result, 100),
+		report:            data.NewReport(),
+		results:           make(map[string]test.ResultType),
+		variables: map[string]string{
+			"stage": fmt.Sprintf("%v", stage),
+			"site":  fmt.Sprintf("%v", siteId),
+		},
+	}
+
+	// Barriers wait for the site again until its run ends
+	ctx.syncManager.StartRun(siteId)
+
+	// Site context is cancelled by ABORT control event, step contexts derived from it are passed to devices
+	siteContext, cancelSite := context.WithCancel(context.Background())
+	defer cancelSite()
+	ctx.ctxMutex.Lock()
+	ctx.siteCancels[siteId] = cancelSite
+	run.report.SetSource(ctx.configSource)
+	ctx.ctxMutex.Unlock()
+	run.report.SetStage(stage)
+	run.report.SetSite(siteId)
+	run.report.AppendReportString("Sequence Started \n")
+	// Identity of instruments used by the site is recorded so every report tells which units took the measurements
+	ctx.ctxMutex.Lock()
+	for _, siteDevice := range ctx.devices {
+		if identifier, ok := siteDevice.(device.Identifier); ok && siteDevice.GetSite() == siteId {
+			run.report.AddInstrument(siteDevice.GetName(), identifier.Identity())
+		}
+	}
+	ctx.ctxMutex.Unlock()
+	// Report is stored at the start so step results can be linked to it as they complete
+	run.report.SetOverallResult(test.InProgress)
+	ctx.ctxMutex.Lock()
+	SendDBData(ctx, run.report)
+	ctx.ctxMutex.Unlock()
+
+	// Main sequence is executed only if setup finished without stopping the run
+	mainResult := run.runSection(sequence.setup, siteContext)
+	if !mainResult.aborted && !mainResult.stopped {
+		result := run.runSection(sequence.main, siteContext)
+		mainResult.failed = mainResult.failed || result.failed
+		mainResult.aborted = result.aborted
+	}
+
+	// Cleanup runs with its own context so it isn't cancelled by abort of main sequence
+	// Its results are stored in report but don't hide main sequence verdict
+	cleanupResult := run.runSection(sequence.cleanup, context.Background())
+
+	// Free locks and semaphores still held by site so other sites don't wait on it
+	ctx.syncManager.ReleaseAll(siteId)
+	ctx.ctxMutex.Lock()
+	delete(ctx.siteCancels, siteId)
+	ctx.ctxMutex.Unlock()
+
+	// Send sequence end events for UI and send report data to db
+	overallResult := test.Pass
+	if mainResult.aborted {
+		overallResult = test.Aborted
+	} else if mainResult.failed || cleanupResult.failed {
+		overallResult = test.Fail
+	}
+	SendSequenceEndEvent(ctx, overallResult, stage, siteId)
+	run.report.SetOverallResult(overallResult)
+	ctx.ctxMutex.Lock()
+	UpdateDBData(ctx, run.report)
+	ctx.ctxMutex.Unlock()
+	return overallResult
+}
+
+// Executes steps of one section. After fail or error step policy decides whether section stops, continues or jumps to labeled step
+// Cleanup section never stops on failure - only jumps are taken into account there
+func (run *sequenceRun) runSection(section sequenceSection, sectionContext context.Context) sectionResult {
+	var outcome sectionResult
+	if len(section.steps) == 0 {
+		return outcome
+	}
+	if section.name != "" {
+		run.report.AppendReportString(section.name + " Started \n")
+	}
+
+	loopCounters := make(map[int]int)
+	for stepIndex := 0; stepIndex < len(section.steps); {
+		// Checked here as well, so flow control steps alone can't keep aborted site running
+		if sectionContext.Err() != nil {
+			outcome.aborted = true
+			break
+		}
+		step := section.steps[stepIndex]
+		stepIndex++
+		enabled := (step.condition == nil || step.condition.Evaluate(run.results)) && (step.skipWhen == nil || !step.skipWhen.Evaluate(run.results))
+
+		switch step.kind {
+		case stepJump:
+			if enabled {
+				stepIndex = step.target
+			}
+			continue
+		case stepLoopStart:
+			if enabled {
+				loopCounters[step.loop] = 0
+			} else {
+				stepIndex = step.target
+			}
+			continue
+		case stepLoopCheck:
+			if (step.count > 0 && loopCounters[step.loop] >= step.count) || (step.while != nil && !step.while.Evaluate(run.results)) {
+				stepIndex = step.target
+			} else {
+				loopCounters[step.loop]++
+			}
+			continue
+		}
+
+		if !enabled {
+			run.skipStep(step.sequenceEvent)
+			run.recordResult(step.label, test.Skipped)
+			continue
+		}
+
+		// Substitute variables into step settings - step referencing undefined variable isn't sent to device
+		var result test.Result
+		var captured []string
+		startedAt := time.Now()
+		sequenceEvent, resolved, err := run.substituteVariables(step.sequenceEvent.Data.(event.SequenceEvent))
+		if err != nil {
+			result = run.finishStepLocally(sequenceEvent, test.Error, err.Error())
+		} else {
+			singleSequenceEvent := step.sequenceEvent
+			singleSequenceEvent.Data = sequenceEvent
+			result, captured = run.executeStep(singleSequenceEvent, step.limits, step.capture, sectionContext)
+		}
+		run.storeStepResult(sequenceEvent.DeviceName, result, startedAt)
+		run.recordResult(step.label, result.Result)
+
+		// Append report with data from test, resolved settings and captured variables
+		run.report.AppendReportString(fmt.Sprintf("%v %s %v: %v (%v) \n", result.Id, result.Result, result.Label, result.Message, result.Retried+1))
+		if len(resolved) > 0 {
+			run.report.AppendReportString("Resolved settings: " + strings.Join(resolved, ", ") + " \n")
+		}
+		if len(captured) > 0 {
+			run.report.AppendReportString("Captured variables: " + strings.Join(captured, ", ") + " \n")
+		}
+		if len(result.Measurements) > 0 {
+			run.report.AppendReportString("Measurements: " + result.MeasurementString() + " \n")
+		}
+		// Aborted sequence finishes regardless of no error mode and policies
+		if result.Result == test.Aborted {
+			outcome.aborted = true
+			break
+		}
+		if result.Result != test.Fail && result.Result != test.Error {
+			continue
+		}
+
+		// Resolve action based on step policy, critical flag and no error mode
+		outcome.failed = true
+		action, label := step.onFail, step.onFailLabel
+		if result.Result == test.Error {
+			action, label = step.onError, step.onErrorLabel
+		}
+		if step.critical {
+			action = config.PolicyCleanup
+		}
+		if action == config.PolicyAbort && run.ctx.noError {
+			action = config.PolicyContinue
+		}
+		if action == config.PolicyGoto {
+			stepIndex = section.labels[label]
+			continue
+		}
+		if action != config.PolicyContinue && section.name != "Cleanup" {
+			outcome.stopped = true
+			break
+		}
+	}
+	return outcome
+}
+
+// Marks step which condition wasn't met as skipped in UI and report
+func (run *sequenceRun) skipStep(singleSequenceEvent event.Event) {
+	sequenceEvent := singleSequenceEvent.Data.(event.SequenceEvent)
+	result := run.finishStepLocally(sequenceEvent, test.Skipped, "Condition not met")
+	run.storeStepResult(sequenceEvent.DeviceName, result, time.Now())
+	run.report.AppendReportString(fmt.Sprintf("%v %s %v: %v \n", result.Id, result.Result, result.Label, result.Message))
+}
+
+// Stores result of step under its label and "last", so conditions of later steps can refer to it
+func (run *sequenceRun) recordResult(label string, result test.ResultType) {
+	run.results["last"] = result
+	if label != "" {
+		run.results[label] = result
+	}
+}
+
+// Stores result of finished step in report database as a row linked to report of the run
+func (run *sequenceRun) storeStepResult(device string, result test.Result, startedAt time.Time) {
+	run.ctx.ctxMutex.Lock()
+	defer run.ctx.ctxMutex.Unlock()
+	SendDBData(run.ctx, data.NewStepResult(run.report.ID, device, result, startedAt, time.Now()))
+}
+
+// Resolves step without sending it to device and shows its result in UI
+func (run *sequenceRun) finishStepLocally(sequenceEvent event.SequenceEvent, resultType test.ResultType, message string) test.Result {
+	result := test.Result{
+		Stage:   run.stage,
+		Site:    sequenceEvent.Site,
+		Id:      sequenceEvent.Id,
+		Label:   sequenceEvent.Label,
+		Result:  resultType,
+		Message: message,
+	}
+	run.ctx.ctxMutex.Lock()
+	SendTestStartedEvent(run.ctx, sequenceEvent.Id, sequenceEvent.Stage, sequenceEvent.Site, sequenceEvent.Label)
+	SendTestResultEvent(run.ctx, result)
+	run.ctx.ctxMutex.Unlock()
+	if run.ctx.graphicInterface == nil {
+		fmt.Println(result)
+	}
+	return result
+}
+
+// Captures variables from data or measurements of successful step - failed capture turns result into error
+func (run *sequenceRun) captureStepVariables(captures map[string]variableCapture, result *test.Result) []string {
+	if len(captures) == 0 || (result.Result != test.Pass && result.Result != test.Done) {
+		return nil
+	}
+	captured, err := run.captureVariables(captures, *result)
+	if err != nil {
+		result.Result = test.Error
+		result.Message += " | " + err.Error()
+	}
+	return captured
+}
+
+// Sends one step to device however many retries where specified by loaded config and returns final result
+// Pass or fail is resolved centrally from measurements returned by device and limits of the step
+// Variables are captured from final result before it is shown, so failed capture is reported as result of the step
+func (run *sequenceRun) executeStep(singleSequenceEvent event.Event, limits []test.Measurement, captures map[string]variableCapture, sectionContext context.Context) (test.Result, []string) {
+	ctx := run.ctx
+	singleSequenceEvent.ReturnChannel = run.siteResultChannel
+	var result test.Result
+	var captured []string
+	// Looping with one event however maany retries where specified by loaded config
+	for retried := range singleSequenceEvent.Data.(event.SequenceEvent).Retry {
+		// Every try gets its own context which is cancelled on step timeout or sequence abort
+		sequenceEventForUI := singleSequenceEvent.Data.(event.SequenceEvent)
+		stepContext, cancelStep := context.WithTimeout(sectionContext, time.Millisecond*time.Duration(sequenceEventForUI.Timeout))
+		sequenceEventForUI.Context = stepContext
+		run.dispatches++
+		sequenceEventForUI.Dispatch = run.dispatches
+		singleSequenceEvent.Data = sequenceEventForUI
+
+		// Route sequence event to addressed device, publish UI events and send logging data to database
+		// Dispatching doesn't need context lock - dispatcher is only read while sequences run
+		dispatchErr := ctx.dispatcher.Dispatch(singleSequenceEvent)
+		ctx.ctxMutex.Lock()
+		SendTestStartedEvent(ctx, sequenceEventForUI.Id, sequenceEventForUI.Stage, sequenceEventForUI.Site, sequenceEventForUI.Label)
+		log := data.NewCustomLog("mainloop", sequenceEventForUI.Label+"| Test started", sequenceEventForUI.Site, data.INFO)
+		SendDebugInfoEvent(ctx, *log)
+		ctx.logDatabase.Create(log)
+		ctx.ctxMutex.Unlock()
+
+		// Select on response to return channel or end of step context - timeout on specified timeout time in config or abort
+		// Waiting and in progress results are intermediate (site blocked on synchronization step, progress of file transfer)
+		// and don't finish the step
+		// Results of other dispatch are late responses to tries that already timed out (also of the same step executed
+		// again by retry, repeat or jump) and are discarded
+		// Step that couldn't be dispatched results in error right away - there is no device that would respond
+		if dispatchErr != nil {
+			result = test.Result{
+				Result:   test.Error,
+				Stage:    run.stage,
+				Site:     sequenceEventForUI.Site,
+				Id:       sequenceEventForUI.Id,
+				Label:    sequenceEventForUI.Label,
+				Message:  dispatchErr.Error(),
+				Retried:  retried,
+				Dispatch: sequenceEventForUI.Dispatch,
+			}
+			if sectionContext.Err() != nil {
+				result.Result = test.Aborted
+				result.Message = "Aborted by operator"
+			}
+		}
+	resultLoop:
+		for dispatchErr == nil {
+			select {
+			case result = <-run.siteResultChannel:
+				if result.Dispatch != sequenceEventForUI.Dispatch {
+					continue
+				}
+				result.Retried = retried
+				result.Stage = run.stage
+				if result.Result == test.Waiting || result.Result == test.InProgress {
+					ctx.ctxMutex.Lock()
+					SendTestWaitingEvent(ctx, result)
+					ctx.ctxMutex.Unlock()
+					continue
+				}
+				break resultLoop
+			case <-stepContext.Done():
+				result = test.Result{
+					Result:   test.Error,
+					Stage:    run.stage,
+					Site:     sequenceEventForUI.Site,
+					Id:       sequenceEventForUI.Id,
+					Label:    sequenceEventForUI.Label,
+					Message:  "Timeout",
+					Retried:  retried,
+					Dispatch: sequenceEventForUI.Dispatch,
+				}
+				if sectionContext.Err() != nil {
+					result.Result = test.Aborted
+					result.Message = "Aborted by operator"
+				}
+				break resultLoop
+			}
+		}
+		cancelStep()
+		if err := test.ApplyLimits(&result, limits); err != nil {
+			result.Result = test.Error
+			result.Message += " | " + err.Error()
+		}
+		captured = run.captureStepVariables(captures, &result)
+
+		// Log result data (UI and db)
+		ctx.ctxMutex.Lock()
+		SendTestResultEvent(ctx, result)
+		var logType data.LogType
+		if result.Result == test.Error {
+			logType = data.ERROR
+		} else {
+			logType = data.INFO
+		}
+		log = data.NewCustomLog(sequenceEventForUI.DeviceName, result.Label+"|Test finished with result: "+result.Message+" "+result.MeasurementString()+" On retry: "+fmt.Sprintf("%v", result.Retried), result.Site, logType)
+		ctx.logDatabase.Create(log)
+		SendDebugInfoEvent(ctx, *log)
+		ctx.ctxMutex.Unlock()
+		// If no graphic engine, print to standard output
+		if ctx.graphicInterface == nil {
+			fmt.Println(result)
+		}
+
+		// If result is not fail - error, pass, done, aborted - break retry loop and continue
+		if result.Result != test.Fail {
+			break
+		}
+	}
+	return result, captured
+}
+
+// Builds setup, sequence and cleanup sections of one site from stage config
+// Step ids are continuous across sections so every step of site run has unique id
+// Labels have to be unique in the whole site sequence, jumps are allowed only inside one section
+// Every problem found is returned as config.StepError, so config validation can report it at position of the step
+func buildSiteSequence(stage config.StageSettings, site int) (siteSequence, error) {
+	var sequence siteSequence
+	builder := sectionBuilder{stage: stage.Stage, site: site, knownLabels: []string{"last"}}
+	sections := []struct {
+		name    string
+		key     string
+		steps   []config.SequenceStepSettings
+		section *sequenceSection
+	}{
+		{"Setup", "setup", stage.Setup, &sequence.setup},
+		{"", "sequence", stage.Sequence, &sequence.main},
+		{"Cleanup", "cleanup", stage.Cleanup, &sequence.cleanup},
+	}
+	for _, section := range sections {
+		builder.collectLabels(section.key, nil, section.steps)
+	}
+	for _, section := range sections {
+		*section.section = builder.build(section.name, section.key, section.steps)
+	}
+	return sequence, errors.Join(builder.errs...)
+}
+
+// Builds site sequence only to check it - used by config validation, so it reports the same problems as loading
+func checkSiteSequence(stage config.StageSettings, site int) error {
+	_, err := buildSiteSequence(stage, site)
+	return err
+}
+
+// Compiles config sections into flat lists of steps for one site
+type sectionBuilder struct {
+	stage       int
+	site        int
+	nextId      int
+	loops       int
+	knownLabels []string
+	errs        []error
+	sectionKey  string
+	section     sequenceSection
+	gotoLabels  map[int]string
+	// Position of config step every compiled step comes from - index of step followed by indexes inside repeat blocks
+	stepPaths [][]int
+}
+
+// Records problem with step at given path, key locates setting of the step it is in
+func (b *sectionBuilder) fail(sectionKey string, path []int, key, message string) {
+	b.errs = append(b.errs, config.StepError{Section: sectionKey, Path: slices.Clone(path), Key: key, Message: message})
+}
+
+// Appends labels of steps (also nested in repeat blocks) to known labels, checking they are unique
+func (b *sectionBuilder) collectLabels(sectionKey string, path []int, steps []config.SequenceStepSettings) {
+	for i, sequenceConfigNode := range steps {
+		stepPath := append(slices.Clone(path), i)
+		if sequenceConfigNode.Label != "" {
+			if slices.Contains(b.knownLabels, sequenceConfigNode.Label) {
+				b.fail(sectionKey, stepPath, "label", "Duplicate or reserved step label: "+sequenceConfigNode.Label)
+			}
+			b.knownLabels = append(b.knownLabels, sequenceConfigNode.Label)
+		}
+		if sequenceConfigNode.Repeat != nil {
+			b.collectLabels(sectionKey, stepPath, sequenceConfigNode.Repeat.Steps)
+		}
+	}
+}
+
+// Builds section of sequence steps from config - repeat blocks are flattened into loop steps and jumps
+// Checks conditions, failure policies and that jump labels exist in the same section
+func (b *sectionBuilder) build(name, key string, steps []config.SequenceStepSettings) sequenceSection {
+	b.sectionKey = key
+	b.section = sequenceSection{
+		name:   name,
+		labels: make(map[string]int),
+	}
+	b.gotoLabels = make(map[int]string)
+	b.stepPaths = nil
+	b.compile(nil, steps)
+
+	// Resolve jump targets after whole section is compiled, so forward jumps work as well
+	for stepIndex := range b.section.steps {
+		label, ok := b.gotoLabels[stepIndex]
+		if !ok {
+			continue
+		}
+		target, ok := b.section.labels[label]
+		if !ok {
+			b.fail(key, b.stepPaths[stepIndex], "goto", "Jump to unknown label: "+label)
+			continue
+		}
+		b.section.steps[stepIndex].target = target
+	}
+	for stepIndex, step := range b.section.steps {
+		for _, policy := range []struct{ key, label string }{{"on_fail", step.onFailLabel}, {"on_error", step.onErrorLabel}} {
+			if _, ok := b.section.labels[policy.label]; policy.label != "" && !ok {
+				b.fail(key, b.stepPaths[stepIndex], policy.key, step.sequenceEvent.Data.(event.SequenceEvent).Label+": jump to unknown label: "+policy.label)
+			}
+		}
+	}
+	return b.section
+}
+
+// Appends compiled step to section together with path of config step it comes from
+func (b *sectionBuilder) appendStep(step sequenceStep, path []int) {
+	b.section.steps = append(b.section.steps, step)
+	b.stepPaths = append(b.stepPaths, path)
+}
+
+func (b *sectionBuilder) compile(path []int, steps []config.SequenceStepSettings) {
+	for i, sequenceConfigNode := range steps {
+		stepPath := append(slices.Clone(path), i)
+		if sequenceConfigNode.Label != "" {
+			b.section.labels[sequenceConfigNode.Label] = len(b.section.steps)
+		}
+		step := sequenceStep{
+			label:    sequenceConfigNode.Label,
+			critical: sequenceConfigNode.Critical,
+		}
+		var err error
+		if step.condition, err = b.parseCondition(sequenceConfigNode.If); err != nil {
+			b.fail(b.sectionKey, stepPath, "if", sequenceConfigNode.StepLabel+": if: "+err.Error())
+		}
+		if step.skipWhen, err = b.parseCondition(sequenceConfigNode.SkipWhen); err != nil {
+			b.fail(b.sectionKey, stepPath, "skip_when", sequenceConfigNode.StepLabel+": skip_when: "+err.Error())
+		}
+
+		switch {
+		case sequenceConfigNode.Goto != "" && sequenceConfigNode.Repeat != nil:
+			b.fail(b.sectionKey, stepPath, "", sequenceConfigNode.StepLabel+": step can't have both goto and repeat")
+		case sequenceConfigNode.Goto != "":
+			step.kind = stepJump
+			b.gotoLabels[len(b.section.steps)] = sequenceConfigNode.Goto
+			b.appendStep(step, stepPath)
+		case sequenceConfigNode.Repeat != nil:
+			b.compileRepeat(step, stepPath, sequenceConfigNode)
+		default:
+			step.kind = stepAction
+			step.sequenceEvent = event.Event{
+				Type: "SequenceEvent",
+				Data: event.SequenceEvent{
+					Id:           uint(b.nextId),
+					Label:        sequenceConfigNode.StepLabel,
+					Stage:        b.stage,
+					Site:         b.site,
+					Retry:        sequenceConfigNode.Retry,
+					DeviceName:   sequenceConfigNode.Device,
+					StepSettings: sequenceConfigNode.StepSettings,
+					Timeout:      sequenceConfigNode.Timeout,
+				},
+			}
+			b.nextId++
+			step.capture = make(map[string]variableCapture)
+			for _, name := range slices.Sorted(maps.Keys(sequenceConfigNode.Capture)) {
+				captureSettings := sequenceConfigNode.Capture[name]
+				capture := variableCapture{measurement: captureSettings.Measurement}
+				if captureSettings.Regex != "" {
+					capture.pattern, err = regexp.Compile(captureSettings.Regex)
+					if err != nil {
+						b.fail(b.sectionKey, stepPath, "capture."+name, sequenceConfigNode.StepLabel+": capture "+name+": "+err.Error())
+					}
+				}
+				step.capture[name] = capture
+			}
+			for limitIndex, limitSettings := range sequenceConfigNode.Limits {
+				limit, err := limitSettings.ToMeasurement()
+				if err != nil {
+					b.fail(b.sectionKey, stepPath, fmt.Sprintf("limits.%v", limitIndex), sequenceConfigNode.StepLabel+": limits: "+err.Error())
+				}
+				step.limits = append(step.limits, limit)
+			}
+			step.onFail, step.onFailLabel, err = config.ParseFailPolicy(sequenceConfigNode.OnFail)
+			if err != nil {
+				b.fail(b.sectionKey, stepPath, "on_fail", sequenceConfigNode.StepLabel+": on_fail: "+err.Error())
+			}
+			step.onError, step.onErrorLabel, err = config.ParseFailPolicy(sequenceConfigNode.OnError)
+			if err != nil {
+				b.fail(b.sectionKey, stepPath, "on_error", sequenceConfigNode.StepLabel+": on_error: "+err.Error())
+			}
+			b.appendStep(step, stepPath)
+		}
+	}
+}
+
+// Repeat block is compiled into: loop start, loop check, block steps and jump back to loop check
+// Loop start (skipped as a whole when block condition isn't met) and loop check jump past the block when it ends
+func (b *sectionBuilder) compileRepeat(step sequenceStep, path []int, sequenceConfigNode config.SequenceStepSettings) {
+	repeat := sequenceConfigNode.Repeat
+	if repeat.Count < 0 || (repeat.Count == 0 && repeat.While == "") {
+		b.fail(b.sectionKey, path, "repeat", sequenceConfigNode.StepLabel+": repeat needs positive count or while condition")
+	}
+	while, err := b.parseCondition(repeat.While)
+	if err != nil {
+		b.fail(b.sectionKey, path, "repeat.while", sequenceConfigNode.StepLabel+": while: "+err.Error())
+	}
+
+	step.kind = stepLoopStart
+	step.loop = b.loops
+	b.loops++
+	startIndex := len(b.section.steps)
+	b.appendStep(step, path)
+	checkIndex := len(b.section.steps)
+	b.appendStep(sequenceStep{
+		kind:  stepLoopCheck,
+		loop:  step.loop,
+		count: repeat.Count,
+		while: while,
+	}, path)
+	b.compile(path, repeat.Steps)
+	b.appendStep(sequenceStep{
+		kind:   stepJump,
+		target: checkIndex,
+	}, path)
+	b.section.steps[startIndex].target = len(b.section.steps)
+	b.section.steps[checkIndex].target = len(b.section.steps)
+}
+
+// Parses condition and checks that it references only labels existing in site sequence
+func (b *sectionBuilder) parseCondition(expression string) (config.Condition, error) {
+	condition, err := config.ParseCondition(expression)
+	if err != nil || condition == nil {
+		return condition, err
+	}
+	for _, label := range condition.Labels() {
+		if !slices.Contains(b.knownLabels, label) {
+			return nil, errors.New("Unknown step label in condition: " + label)
+		}
+	}
+	return condition, nil
+}
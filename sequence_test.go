@@ -0,0 +1,345 @@
+package main
+
+import (
+	"checkerbox/internal/config"
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Graphic interface dropping every event - sequence results are collected from event bus by tests
+type discardInterface struct {
+	events chan event.Event
+}
+
+func (d *discardInterface) GraphicEventHandler() {
+	for range d.events {
+	}
+}
+
+func (d *discardInterface) GetEventChannel() chan event.Event {
+	return d.events
+}
+
+func (d *discardInterface) Stop() {}
+
+// Step of test device returning given value - limit of the step fails values below 5
+// Extra lines (label, if, on_fail...) are added to step settings, retry defaults to 1
+func measureStep(stepLabel string, value int, extra ...string) string {
+	step := "- step_label: " + stepLabel + "\n"
+	if !slices.ContainsFunc(extra, func(line string) bool { return strings.HasPrefix(line, "retry:") }) {
+		extra = append(extra, "retry: 1")
+	}
+	for _, line := range extra {
+		step += "  " + line + "\n"
+	}
+	return step + fmt.Sprintf(`  device: testdevice
+  timeout: 1000
+  limits:
+  - comparison: GE
+    low: 5
+  stepsettings:
+      function: TestMeasure
+      value: %v
+`, value)
+}
+
+// Step resulting in error - it references undefined variable, so it isn't sent to any device
+func errorStep(stepLabel string, extra ...string) string {
+	step := "- step_label: " + stepLabel + "\n  retry: 1\n"
+	for _, line := range extra {
+		step += "  " + line + "\n"
+	}
+	return step + `  device: sequence
+  timeout: 1000
+  stepsettings:
+      function: Wait
+      time: "${undefined}"
+`
+}
+
+// Loads config with test device into application context and runs sequence of site 0
+// Returns overall result and "label:result" of every finished try in order
+func runSequence(t *testing.T, sections string) (test.ResultType, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	appPath, configPath := filepath.Join(dir, "app.yml"), filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(appPath, []byte("sites: 1\nstages: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configFile := "hardware:\n- site: 0\n  device_name: testdevice\n" + sections
+	if err := os.WriteFile(configPath, []byte(configFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &applicationContext{options: runOptions{appPath: appPath, configPath: configPath, dataDir: dir}}
+	ctx.graphicInterface = &discardInterface{events: make(chan event.Event)}
+	ctx.uiReturnChannel = make(chan event.ControlEvent)
+	if err := loadAppSettings(ctx); err != nil {
+		t.Fatalf("Loading app settings failed: %v", err)
+	}
+	collected := make(chan event.Event, 1000)
+	ctx.eventBus.Subscribe("graphicEvent", collected)
+	if err := reloadConfiguration(ctx, configPath); err != nil {
+		t.Fatalf("Loading config failed: %v\n%s", err, configFile)
+	}
+	defer shutdownDevices(ctx)
+
+	overall := handleSequence(ctx.sequenceEventLists[0][0], ctx, 0, 0)
+	var trace []string
+	for len(collected) > 0 {
+		graphicEvent := (<-collected).Data.(event.GraphicEvent)
+		if graphicEvent.Type == "testResult" {
+			trace = append(trace, graphicEvent.Result.Label+":"+graphicEvent.Result.Result.String())
+		}
+	}
+	return overall, trace
+}
+
+func TestSequenceFlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		overall  test.ResultType
+		expected []string
+	}{
+		{
+			name:     "steps run in order",
+			config:   "sequence:\n" + measureStep("A", 9) + measureStep("B", 9),
+			overall:  test.Pass,
+			expected: []string{"A:Pass", "B:Pass"},
+		},
+		{
+			name:     "fail stops sequence and runs cleanup",
+			config:   "sequence:\n" + measureStep("A", 1) + measureStep("B", 9) + "cleanup:\n" + measureStep("Off", 9),
+			overall:  test.Fail,
+			expected: []string{"A:Fail", "Off:Pass"},
+		},
+		{
+			name:     "fail is retried",
+			config:   "sequence:\n" + measureStep("A", 1, "retry: 3"),
+			overall:  test.Fail,
+			expected: []string{"A:Fail", "A:Fail", "A:Fail"},
+		},
+		{
+			name:     "pass isn't retried",
+			config:   "sequence:\n" + measureStep("A", 9, "retry: 3"),
+			overall:  test.Pass,
+			expected: []string{"A:Pass"},
+		},
+		{
+			name:     "error isn't retried",
+			config:   "sequence:\n" + errorStep("A", "on_error: continue") + measureStep("B", 9),
+			overall:  test.Fail,
+			expected: []string{"A:Error", "B:Pass"},
+		},
+		{
+			name:     "continue policy",
+			config:   "sequence:\n" + measureStep("A", 1, "on_fail: continue") + measureStep("B", 9),
+			overall:  test.Fail,
+			expected: []string{"A:Fail", "B:Pass"},
+		},
+		{
+			name: "goto policy",
+			config: "sequence:\n" + measureStep("A", 1, "on_fail: goto:recover") + measureStep("B", 9) +
+				measureStep("Recover", 9, "label: recover"),
+			overall:  test.Fail,
+			expected: []string{"A:Fail", "Recover:Pass"},
+		},
+		{
+			name: "jump skips steps",
+			config: "sequence:\n" + measureStep("A", 9) + "- step_label: Jump\n  goto: end\n" + measureStep("B", 9) +
+				measureStep("End", 9, "label: end"),
+			overall:  test.Pass,
+			expected: []string{"A:Pass", "End:Pass"},
+		},
+		{
+			name:     "condition on skipped step",
+			config:   "sequence:\n" + measureStep("A", 9, "label: a", "if: last == Fail") + measureStep("B", 9, "if: a == Skipped"),
+			overall:  test.Pass,
+			expected: []string{"A:Skipped", "B:Pass"},
+		},
+		{
+			name:     "last refers to skipped step",
+			config:   "sequence:\n" + measureStep("A", 9) + measureStep("B", 9, "skip_when: last == Pass") + measureStep("C", 9, "if: last == Skipped"),
+			overall:  test.Pass,
+			expected: []string{"A:Pass", "B:Skipped", "C:Pass"},
+		},
+		{
+			name: "condition on failed step",
+			config: "sequence:\n" + measureStep("A", 1, "label: a", "on_fail: continue") + measureStep("B", 9, "if: a == Fail") +
+				measureStep("C", 9, "if: a == Pass"),
+			overall:  test.Fail,
+			expected: []string{"A:Fail", "B:Pass", "C:Skipped"},
+		},
+		{
+			name: "condition on error step",
+			config: "sequence:\n" + errorStep("A", "label: a", "on_error: continue") + measureStep("B", 9, "if: a == Error") +
+				measureStep("C", 9, "skip_when: a == Error"),
+			overall:  test.Fail,
+			expected: []string{"A:Error", "B:Pass", "C:Skipped"},
+		},
+		{
+			name:     "repeat count",
+			config:   "sequence:\n- step_label: Loop\n  repeat:\n    count: 3\n    steps:\n" + indent(measureStep("A", 9), 4) + measureStep("B", 9),
+			overall:  test.Pass,
+			expected: []string{"A:Pass", "A:Pass", "A:Pass", "B:Pass"},
+		},
+		{
+			name: "repeat while",
+			config: "sequence:\n- step_label: Loop\n  repeat:\n    count: 5\n    while: done != Pass\n    steps:\n" +
+				indent(measureStep("A", 9)+measureStep("Done", 9, "label: done", "if: last == Pass"), 4),
+			overall:  test.Pass,
+			expected: []string{"A:Pass", "Done:Pass"},
+		},
+		{
+			name:     "repeat block skipped by condition",
+			config:   "sequence:\n- step_label: Loop\n  if: last == Fail\n  repeat:\n    count: 3\n    steps:\n" + indent(measureStep("A", 9), 4) + measureStep("B", 9),
+			overall:  test.Pass,
+			expected: []string{"B:Pass"},
+		},
+		{
+			name:     "cleanup runs all steps after fail",
+			config:   "sequence:\n" + measureStep("A", 1) + "cleanup:\n" + measureStep("C1", 1) + measureStep("C2", 9),
+			overall:  test.Fail,
+			expected: []string{"A:Fail", "C1:Fail", "C2:Pass"},
+		},
+		{
+			name: "captured measurement is substituted",
+			config: "sequence:\n" + measureStep("A", 7, "capture:", "  v: {measurement: 0}") +
+				strings.Replace(measureStep("B", 0), "value: 0", `value: "${v}"`, 1),
+			overall:  test.Pass,
+			expected: []string{"A:Pass", "B:Pass"},
+		},
+		{
+			name:     "failed capture is the only result shown",
+			config:   "sequence:\n" + measureStep("A", 7, "on_error: continue", "capture:", "  v: {measurement: 3}") + measureStep("B", 9, "if: last == Error"),
+			overall:  test.Fail,
+			expected: []string{"A:Error", "B:Pass"},
+		},
+		{
+			name:     "result message isn't captured",
+			config:   "sequence:\n" + measureStep("A", 7, "capture:", "  v: \"(.*)\""),
+			overall:  test.Fail,
+			expected: []string{"A:Error"},
+		},
+		{
+			name:     "critical step stops run despite policy",
+			config:   "sequence:\n" + measureStep("A", 1, "on_fail: continue", "critical: true") + measureStep("B", 9),
+			overall:  test.Fail,
+			expected: []string{"A:Fail"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overall, trace := runSequence(t, tt.config)
+			if overall != tt.overall {
+				t.Errorf("Overall result %s, expected %s", overall, tt.overall)
+			}
+			if !slices.Equal(trace, tt.expected) {
+				t.Errorf("Executed %v, expected %v", trace, tt.expected)
+			}
+		})
+	}
+}
+
+// Indents every line of YAML block, so steps can be nested in repeat block
+func indent(block string, spaces int) string {
+	prefix := strings.Repeat(" ", spaces)
+	return prefix + strings.ReplaceAll(strings.TrimSuffix(block, "\n"), "\n", "\n"+prefix) + "\n"
+}
+
+func TestBuildSiteSequenceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"jump to unknown label", "- step_label: Jump\n  goto: nowhere\n", "Jump to unknown label: nowhere"},
+		{"policy to unknown label", measureStep("A", 9, "on_fail: goto:nowhere"), "A: jump to unknown label: nowhere"},
+		{"unknown label in condition", measureStep("A", 9, "if: nowhere == Pass"), "A: if: Unknown step label in condition: nowhere"},
+		{"duplicate label", measureStep("A", 9, "label: a") + measureStep("B", 9, "label: a"), "Duplicate or reserved step label: a"},
+		{"reserved label", measureStep("A", 9, "label: last"), "Duplicate or reserved step label: last"},
+		{"goto and repeat", "- step_label: Both\n  goto: a\n  repeat:\n    count: 1\n", "Both: step can't have both goto and repeat"},
+		{"repeat without count", "- step_label: Loop\n  repeat:\n    steps: []\n", "Loop: repeat needs positive count or while condition"},
+		{"invalid policy", measureStep("A", 9, "on_error: retry"), "A: on_error: Unknown policy: retry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var steps []config.SequenceStepSettings
+			if err := yaml.Unmarshal([]byte(tt.config), &steps); err != nil {
+				t.Fatal(err)
+			}
+			_, err := buildSiteSequence(config.StageSettings{Sequence: steps}, 0)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("Expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateSequencePositions(t *testing.T) {
+	file := `hardware:
+- site: 0
+  device_name: testdevice
+sequence:
+- step_label: A
+  label: a
+  retry: 1
+  timeout: 100
+  device: testdevice
+  on_fail: goto:nowhere
+  if: b == Pass
+  stepsettings:
+    function: TestAction1
+- step_label: Loop
+  repeat:
+    count: 2
+    while: x == Bad
+    steps:
+    - step_label: Inner
+      label: a
+      retry: 1
+      timeout: 100
+      device: testdevice
+      limits:
+      - comparison: XX
+      capture:
+        v: "("
+      stepsettings:
+        function: TestAction1
+cleanup:
+- step_label: J
+  goto: missing
+`
+	expected := []string{
+		"line 10, column 12: A: jump to unknown label: nowhere",
+		"line 11, column 7: A: if: Unknown step label in condition: b",
+		`line 17, column 12: Loop: while: Invalid condition "x == Bad": unknown result: Bad`,
+		"line 20, column 14: Duplicate or reserved step label: a",
+		"line 25, column 9: Inner: limits: Unknown comparison: XX",
+		"line 27, column 12: Inner: capture v: error parsing regexp: missing closing ): `(`",
+		"line 32, column 9: Jump to unknown label: missing",
+	}
+	var messages []string
+	for _, validationError := range config.ValidateConfig([]byte(file), config.AppSettings{Sites: 1}, checkSiteSequence) {
+		messages = append(messages, validationError.Error())
+	}
+	if !slices.Equal(messages, expected) {
+		t.Errorf("Validation errors:\n%s\nexpected:\n%s", strings.Join(messages, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// Misspelled step key is reported instead of being ignored
+	misspelled := strings.Replace(file, "  timeout: 100\n  device", "  tiemout: 100\n  device", 1)
+	validationErrors := config.ValidateConfig([]byte(misspelled), config.AppSettings{Sites: 1}, checkSiteSequence)
+	if len(validationErrors) != 1 || validationErrors[0].Error() != "line 8: field tiemout not found in type config.SequenceStepSettings" {
+		t.Errorf("Expected misspelled key error, got %v", validationErrors)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"checkerbox/internal/config"
+	"checkerbox/internal/event"
+	"checkerbox/internal/test"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// Returns copy of sequence event with variables substituted in string values of step settings
+// Also returns list of settings that contained variables with their resolved values, so they can be stored in report
+func (run *sequenceRun) substituteVariables(sequenceEvent event.SequenceEvent) (event.SequenceEvent, []string, error) {
+	var resolved []string
+	stepSettings, err := run.substituteValue(sequenceEvent.StepSettings, "", &resolved)
+	if err != nil {
+		return sequenceEvent, resolved, err
+	}
+	sequenceEvent.StepSettings, _ = stepSettings.(map[string]any)
+	return sequenceEvent, resolved, nil
+}
+
+// Walks through settings value recursively - maps and lists are copied, so config shared between runs is never modified
+func (run *sequenceRun) substituteValue(value any, path string, resolved *[]string) (any, error) {
+	switch typedValue := value.(type) {
+	case string:
+		var missing []string
+		substituted := config.VariableReference.ReplaceAllStringFunc(typedValue, func(reference string) string {
+			name := config.VariableReference.FindStringSubmatch(reference)[1]
+			variable, ok := run.variables[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			return variable
+		})
+		if len(missing) > 0 {
+			return nil, errors.New("Undefined variable: " + missing[0])
+		}
+		if substituted != typedValue {
+			*resolved = append(*resolved, path+"="+substituted)
+		}
+		return substituted, nil
+	case map[string]any:
+		substitutedMap := make(map[string]any, len(typedValue))
+		for _, key := range slices.Sorted(maps.Keys(typedValue)) {
+			substitutedValue, err := run.substituteValue(typedValue[key], joinPath(path, key), resolved)
+			if err != nil {
+				return nil, err
+			}
+			substitutedMap[key] = substitutedValue
+		}
+		return substitutedMap, nil
+	case []any:
+		substitutedList := make([]any, len(typedValue))
+		for i, element := range typedValue {
+			substitutedValue, err := run.substituteValue(element, joinPath(path, fmt.Sprintf("%v", i)), resolved)
+			if err != nil {
+				return nil, err
+			}
+			substitutedList[i] = substitutedValue
+		}
+		return substitutedList, nil
+	default:
+		return value, nil
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Capture of one variable - measurement selects measurement of result by name or index, when empty data returned by device is used
+// Nil pattern stores selected value whole
+type variableCapture struct {
+	measurement string
+	pattern     *regexp.Regexp
+}
+
+// Stores data returned by device (or part of it matched by capture regex) in variables of the site run
+// Regex with group stores first group, regex without groups stores whole match
+func (run *sequenceRun) captureVariables(captures map[string]variableCapture, result test.Result) ([]string, error) {
+	var captured []string
+	for _, name := range slices.Sorted(maps.Keys(captures)) {
+		capture := captures[name]
+		value, err := capture.source(result)
+		if err != nil {
+			return captured, errors.New("Capture of " + name + " failed: " + err.Error())
+		}
+		if capture.pattern != nil {
+			match := capture.pattern.FindStringSubmatch(value)
+			if match == nil {
+				return captured, errors.New("Capture of " + name + " failed: no match for " + capture.pattern.String())
+			}
+			value = match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+		}
+		run.variables[name] = value
+		captured = append(captured, name+"="+value)
+	}
+	return captured, nil
+}
+
+// Returns text capture is taken from - data returned by device, or measurement selected by name first, then by index
+// Result message is never captured, so capture of step that returned no data fails instead of matching log text
+func (c variableCapture) source(result test.Result) (string, error) {
+	if c.measurement == "" {
+		if result.Data == "" {
+			return "", errors.New("no data returned by step, measurement has to be selected")
+		}
+		return result.Data, nil
+	}
+	for _, measurement := range result.Measurements {
+		if measurement.Name == c.measurement {
+			return measurement.Text, nil
+		}
+	}
+	if index, err := strconv.Atoi(c.measurement); err == nil && index >= 0 && index < len(result.Measurements) {
+		return result.Measurements[index].Text, nil
+	}
+	return "", errors.New("no measurement " + c.measurement)
+}
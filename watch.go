@@ -0,0 +1,49 @@
+package main
+
+import (
+	"checkerbox/internal/event"
+	"os"
+	"sync"
+	"time"
+)
+
+// Polls modification time of active config file and notifies main loop when it changes on disk
+type configWatcher struct {
+	mutex   sync.Mutex
+	path    string
+	modTime time.Time
+}
+
+// Sets file that is watched - its current modification time is the reference for later changes
+func (w *configWatcher) setPath(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.path = path
+	w.modTime = time.Time{}
+	if info, err := os.Stat(path); err == nil {
+		w.modTime = info.ModTime()
+	}
+}
+
+// Checks watched file every interval and sends "CONFIGCHANGED" control event with its path when it was modified
+func (w *configWatcher) run(interval time.Duration, notifyChannel chan<- event.ControlEvent) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.mutex.Lock()
+		path := w.path
+		changed := false
+		if info, err := os.Stat(path); path != "" && err == nil && !info.ModTime().Equal(w.modTime) {
+			w.modTime = info.ModTime()
+			changed = true
+		}
+		w.mutex.Unlock()
+
+		if changed {
+			notifyChannel <- event.ControlEvent{
+				Type: "CONFIGCHANGED",
+				Data: path,
+			}
+		}
+	}
+}